@@ -0,0 +1,427 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	actionDelete   = "delete"
+	actionHardlink = "hardlink"
+	actionReflink  = "reflink"
+)
+
+// errReflinkUnsupported is returned by cloneFile where the file system or
+// platform cannot create copy-on-write clones.
+var errReflinkUnsupported = errors.New("reflinks are not supported here")
+
+var keepStrategies = []string{"first", "newest", "oldest", "shortest-path", "longest-path", "newest-per-dir"}
+
+// Grouping keys selectable with --match-on. Matching on the size as well
+// guards against a hash collision between files of different sizes, which
+// is only a practical concern for truncated hashes.
+const (
+	matchHash     = "hash"
+	matchHashSize = "hash+size"
+)
+
+var matchModes = []string{matchHash, matchHashSize}
+
+func validateMatchOn(mode string) error {
+	if !slices.Contains(matchModes, mode) {
+		return fmt.Errorf("unknown match mode %q (expected one of: %s)", mode, strings.Join(matchModes, ", "))
+	}
+
+	return nil
+}
+
+// groupKey returns the value files must share to be duplicates under
+// matchOn.
+func groupKey(file HashedFileInfo, matchOn string) string {
+	if matchOn == matchHashSize {
+		return file.Hash + "/" + strconv.FormatInt(file.Size, 10)
+	}
+
+	return file.Hash
+}
+
+func validateKeepStrategy(strategy string) error {
+	for _, s := range keepStrategies {
+		if strategy == s {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown keep strategy %q (expected one of: %s)", strategy, strings.Join(keepStrategies, ", "))
+}
+
+// findDuplicateGroups groups files by hash, or by hash and size as selected
+// by matchOn, and returns the groups with at least minGroupSize members, in
+// the order their key was first seen. Links to a file that is already part
+// of the scan are not separate copies and are left out.
+func findDuplicateGroups(files []HashedFileInfo, minGroupSize int, matchOn string) [][]HashedFileInfo {
+	var order []string
+	groups := make(map[string][]HashedFileInfo)
+
+	for _, file := range files {
+		if file.LinkedTo != "" {
+			continue
+		}
+
+		key := groupKey(file, matchOn)
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], file)
+	}
+
+	var duplicates [][]HashedFileInfo
+	for _, key := range order {
+		if len(groups[key]) >= minGroupSize {
+			duplicates = append(duplicates, groups[key])
+		}
+	}
+
+	return duplicates
+}
+
+// assignGroupIDs sets the GroupID of each of files that is in one of groups
+// to the position of that group, counting from 1.
+func assignGroupIDs(files []HashedFileInfo, groups [][]HashedFileInfo) {
+	ids := make(map[string]int)
+	for i, group := range groups {
+		for _, file := range group {
+			ids[file.Path] = i + 1
+		}
+	}
+
+	for i := range files {
+		files[i].GroupID = ids[files[i].Path]
+	}
+}
+
+// withoutEmptyFiles returns files without the zero-byte ones, so that
+// --include-zero-size-as-unique can list them without grouping them.
+func withoutEmptyFiles(files []HashedFileInfo) []HashedFileInfo {
+	var nonEmpty []HashedFileInfo
+	for _, file := range files {
+		if file.Size > 0 {
+			nonEmpty = append(nonEmpty, file)
+		}
+	}
+
+	return nonEmpty
+}
+
+// keeperFiles returns the files that remain after keeping one file per
+// duplicate group: the keeper of every group plus every file that is not a
+// duplicate, in scan order. Links to other scanned files are left out since
+// their target is already listed.
+func keeperFiles(files []HashedFileInfo, groups [][]HashedFileInfo, strategy string, canonicalDir string) []HashedFileInfo {
+	redundant := make(map[string]bool)
+	for _, group := range groups {
+		keepers := selectKeepers(group, strategy, canonicalDir)
+
+		for i, file := range group {
+			if keepers[i] != i {
+				redundant[file.Path] = true
+			}
+		}
+	}
+
+	var keepers []HashedFileInfo
+	for _, file := range files {
+		if file.LinkedTo == "" && !redundant[file.Path] {
+			keepers = append(keepers, file)
+		}
+	}
+
+	return keepers
+}
+
+// uniqueFiles returns the files that are in none of groups, in scan order:
+// those no other scanned file duplicates. Links to other scanned files are
+// left out since their target is already accounted for.
+func uniqueFiles(files []HashedFileInfo, groups [][]HashedFileInfo) []HashedFileInfo {
+	duplicated := make(map[string]bool)
+	for _, group := range groups {
+		for _, file := range group {
+			duplicated[file.Path] = true
+		}
+	}
+
+	var uniques []HashedFileInfo
+	for _, file := range files {
+		if file.LinkedTo == "" && !duplicated[file.Path] {
+			uniques = append(uniques, file)
+		}
+	}
+
+	return uniques
+}
+
+// duplicateFiles returns the files that are in one of groups, in scan order.
+func duplicateFiles(files []HashedFileInfo, groups [][]HashedFileInfo) []HashedFileInfo {
+	inGroup := make(map[string]bool)
+	for _, group := range groups {
+		for _, file := range group {
+			inGroup[file.Path] = true
+		}
+	}
+
+	var duplicates []HashedFileInfo
+	for _, file := range files {
+		if inGroup[file.Path] {
+			duplicates = append(duplicates, file)
+		}
+	}
+
+	return duplicates
+}
+
+// filterBySavings drops the groups that would free less than minSavings
+// bytes when all but one copy is removed.
+func filterBySavings(groups [][]HashedFileInfo, minSavings int64) [][]HashedFileInfo {
+	if minSavings <= 0 {
+		return groups
+	}
+
+	var filtered [][]HashedFileInfo
+	for _, group := range groups {
+		if reclaimableBytes([][]HashedFileInfo{group}) >= minSavings {
+			filtered = append(filtered, group)
+		}
+	}
+
+	return filtered
+}
+
+// groupsChangedSince keeps the groups with at least one file modified after
+// since, which are the duplicates a --since-last-run scan reports.
+func groupsChangedSince(groups [][]HashedFileInfo, since time.Time) [][]HashedFileInfo {
+	var changed [][]HashedFileInfo
+	for _, group := range groups {
+		if slices.ContainsFunc(group, func(file HashedFileInfo) bool { return file.ModTime.After(since) }) {
+			changed = append(changed, group)
+		}
+	}
+
+	return changed
+}
+
+// filesChangedSince returns, in scan order, the files modified after since
+// and the files in groups, which are the earlier files they duplicate.
+func filesChangedSince(files []HashedFileInfo, groups [][]HashedFileInfo, since time.Time) []HashedFileInfo {
+	inGroup := make(map[string]bool)
+	for _, group := range groups {
+		for _, file := range group {
+			inGroup[file.Path] = true
+		}
+	}
+
+	var changed []HashedFileInfo
+	for _, file := range files {
+		if file.ModTime.After(since) || inGroup[file.Path] {
+			changed = append(changed, file)
+		}
+	}
+
+	return changed
+}
+
+// selectKeepers returns, for every file in a duplicate group, the index of
+// the file kept in its place, which is its own index for kept files. The
+// newest-per-dir strategy keeps the newest copy in each directory, so
+// copies in different directories are all kept; every other strategy keeps
+// a single file, chosen by selectKeeper.
+func selectKeepers(group []HashedFileInfo, strategy string, canonicalDir string) []int {
+	keepers := make([]int, len(group))
+
+	if strategy != "newest-per-dir" {
+		keeper := selectKeeper(group, strategy, canonicalDir)
+		for i := range keepers {
+			keepers[i] = keeper
+		}
+
+		return keepers
+	}
+
+	newestInDir := make(map[string]int)
+	for i, file := range group {
+		dir := filepath.Dir(file.Path)
+
+		newest, ok := newestInDir[dir]
+		if !ok || file.ModTime.After(group[newest].ModTime) {
+			newestInDir[dir] = i
+		}
+	}
+
+	for i, file := range group {
+		keepers[i] = newestInDir[filepath.Dir(file.Path)]
+	}
+
+	return keepers
+}
+
+// selectKeeper returns the index of the file to keep in a duplicate group.
+// Files inside canonicalDir are preferred; the strategy breaks ties among them.
+func selectKeeper(group []HashedFileInfo, strategy string, canonicalDir string) int {
+	candidates := make([]int, 0, len(group))
+
+	if canonicalDir != "" {
+		for i, file := range group {
+			if isWithinDir(file.Path, canonicalDir) {
+				candidates = append(candidates, i)
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		for i := range group {
+			candidates = append(candidates, i)
+		}
+	}
+
+	keeper := candidates[0]
+	for _, i := range candidates[1:] {
+		file, best := group[i], group[keeper]
+
+		switch strategy {
+		case "newest":
+			if file.ModTime.After(best.ModTime) {
+				keeper = i
+			}
+		case "oldest":
+			if file.ModTime.Before(best.ModTime) {
+				keeper = i
+			}
+		case "shortest-path":
+			if len(file.Path) < len(best.Path) {
+				keeper = i
+			}
+		case "longest-path":
+			if len(file.Path) > len(best.Path) {
+				keeper = i
+			}
+		}
+	}
+
+	return keeper
+}
+
+func isWithinDir(path string, dir string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// applyAction removes or hard links duplicate so that it no longer takes up
+// space separately from keeper.
+func applyAction(action string, keeper HashedFileInfo, duplicate HashedFileInfo) error {
+	keeperInfo, err := os.Stat(keeper.Path)
+	if err != nil {
+		return fmt.Errorf("failed to stat kept file %s: %w", keeper.Path, err)
+	}
+
+	duplicateInfo, err := os.Stat(duplicate.Path)
+	if err != nil {
+		return fmt.Errorf("failed to stat duplicate %s: %w", duplicate.Path, err)
+	}
+
+	// Removing or relinking a path that already points at the kept file
+	// (a hard link or symlink to it) would reclaim nothing and could take
+	// the kept content with it.
+	if os.SameFile(keeperInfo, duplicateInfo) {
+		return fmt.Errorf("%s is already the same file as %s", duplicate.Path, keeper.Path)
+	}
+
+	switch action {
+	case actionDelete:
+		err = os.Remove(duplicate.Path)
+		if err != nil {
+			return fmt.Errorf("failed to delete %s: %w", duplicate.Path, err)
+		}
+	case actionHardlink:
+		err = hardlinkFile(keeper.Path, duplicate.Path)
+		if err != nil {
+			return err
+		}
+	case actionReflink:
+		err = reflinkFile(keeper.Path, duplicate.Path, duplicateInfo)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown action: %s", action)
+	}
+
+	return nil
+}
+
+// hardlinkFile replaces duplicate with a hard link to keeper. The link is
+// created under a temporary name first so duplicate is never missing if
+// linking fails.
+func hardlinkFile(keeper string, duplicate string) error {
+	tmpPath := duplicate + ".dupe-d.tmp"
+
+	err := os.Link(keeper, tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to hard link %s to %s: %w", duplicate, keeper, err)
+	}
+
+	err = os.Rename(tmpPath, duplicate)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace %s with hard link: %w", duplicate, err)
+	}
+
+	return nil
+}
+
+// reflinkFile replaces duplicate with a copy-on-write clone of keeper, so
+// the two share their data blocks but stay independent files: writing to
+// one leaves the other unchanged. The clone gets the permissions and
+// modification time of the file it replaces, and like a hard link it is
+// created under a temporary name first.
+func reflinkFile(keeper string, duplicate string, info fs.FileInfo) error {
+	tmpPath := duplicate + ".dupe-d.tmp"
+
+	err := cloneFile(keeper, tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to reflink %s to %s: %w", duplicate, keeper, err)
+	}
+
+	err = os.Chmod(tmpPath, info.Mode().Perm())
+	if err == nil {
+		err = os.Chtimes(tmpPath, info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, duplicate)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace %s with reflink: %w", duplicate, err)
+	}
+
+	return nil
+}
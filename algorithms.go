@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/xxh3"
+)
+
+const defaultAlgorithm = "sha256"
+
+// hashAlgorithms lists the algorithms selectable with --algo, each also with
+// treeSuffix. xxh64 and xxh128 are not cryptographic: they are much faster,
+// but files can be made to collide on purpose, so they only suit finding
+// accidental duplicates.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"blake3": func() hash.Hash { return blake3.New() },
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"xxh64":  func() hash.Hash { return xxhash.New() },
+	"xxh128": func() hash.Hash { return xxh128{xxh3.New()} },
+}
+
+// xxh128 is the 128-bit variant of XXH3; xxh3.Hasher's own Sum returns the
+// 64-bit digest.
+type xxh128 struct {
+	*xxh3.Hasher
+}
+
+func (h xxh128) Size() int {
+	return 16
+}
+
+func (h xxh128) Sum(b []byte) []byte {
+	sum := h.Sum128().Bytes()
+	return append(b, sum[:]...)
+}
+
+func algorithmNames() []string {
+	names := make([]string, 0, len(hashAlgorithms))
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func validateAlgorithm(algo string) error {
+	if _, ok := hashAlgorithms[strings.TrimSuffix(algo, treeSuffix)]; !ok {
+		return fmt.Errorf("unknown hash algorithm %q (expected one of: %s, optionally followed by %s)", algo, strings.Join(algorithmNames(), ", "), treeSuffix)
+	}
+
+	return nil
+}
+
+func newHasher(algo string) (hash.Hash, error) {
+	newFunc, ok := hashAlgorithms[strings.TrimSuffix(algo, treeSuffix)]
+	if !ok {
+		return nil, validateAlgorithm(algo)
+	}
+
+	if strings.HasSuffix(algo, treeSuffix) {
+		return newTreeHash(newFunc), nil
+	}
+
+	return newFunc(), nil
+}
@@ -0,0 +1,731 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	applyDelete   bool
+	applyHardlink bool
+	applyReflink  bool
+	applyDryRun   bool
+	assumeSorted  bool
+	applyYes      bool
+	safeMode      bool
+	keepStrategy  string
+	canonicalDir  string
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply <results.csv>",
+	Short: "Delete, hard link or reflink duplicates listed in a saved results file",
+	Long: `apply reads a CSV written by a previous dupe-d scan, groups its rows by hash
+and deletes, hard links or reflinks the redundant copies in each group without
+re-scanning.
+Every listed file is re-hashed first; files that changed or disappeared since the
+scan are left untouched. Pass the same --hash-include-* flags that were used for
+the scan so the re-computed hashes match. apply asks for confirmation before
+changing anything; --yes skips the question and is required when not running
+in a terminal.`,
+	Example: `  dupe-d apply hash_results_20250101_120000.csv --delete
+  dupe-d apply results.csv --hardlink --keep newest
+  dupe-d apply results.csv --reflink
+  dupe-d apply results.csv --delete --canonical-dir /photos/originals --dry-run`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		action, err := getAction(applyDelete, applyHardlink, applyReflink)
+		if err != nil {
+			return err
+		}
+
+		err = validateKeepStrategy(keepStrategy)
+		if err != nil {
+			return err
+		}
+
+		err = validateMatchOn(matchOn)
+		if err != nil {
+			return err
+		}
+
+		if assumeSorted {
+			return applySorted(cmd.Context(), args[0], action)
+		}
+
+		files, err := readResultsCsv(args[0])
+		if err != nil {
+			return err
+		}
+
+		err = checkAlgorithm(files, args[0], hashOpts.algo)
+		if err != nil {
+			return err
+		}
+
+		groups, err := verifyGroups(cmd.Context(), findDuplicateGroups(files, 2, matchOn))
+		if err != nil {
+			return err
+		}
+
+		groups = filterBySavings(groups, int64(minSavings))
+
+		if !applyDryRun {
+			err = confirmApply(action, plannedTotals(groups), false)
+			if err != nil {
+				return err
+			}
+		}
+
+		return applyToGroups(groups, action, applyDryRun)
+	},
+}
+
+func init() {
+	applyCmd.Flags().BoolVar(&applyDelete, "delete", false, "Delete redundant copies")
+	applyCmd.Flags().BoolVar(&applyHardlink, "hardlink", false, "Replace redundant copies with hard links to the kept file")
+	applyCmd.Flags().BoolVar(&applyReflink, "reflink", false, "Replace redundant copies with copy-on-write clones of the kept file (btrfs, XFS, APFS)")
+	applyCmd.Flags().BoolVar(&applyDryRun, "dry-run", false, "Print what would be done without changing any files")
+	applyCmd.Flags().BoolVarP(&applyYes, "yes", "y", false, "Act without asking for confirmation")
+	applyCmd.Flags().BoolVar(&safeMode, "safe-mode", true, "Stop rather than act on a group in which no copy would be kept, and never keep a symlink in place of a copy")
+	applyCmd.Flags().StringVar(&keepStrategy, "keep", "first", "Which file to keep in each group: "+strings.Join(keepStrategies, ", "))
+	applyCmd.Flags().Var(&minSavings, "min-savings", "Only act on duplicate groups that would free at least this much space (e.g. 100MB)")
+	applyCmd.Flags().StringVar(&matchOn, "match-on", matchHash, "What files must share to be duplicates: "+strings.Join(matchModes, ", "))
+	applyCmd.Flags().StringVar(&canonicalDir, "canonical-dir", "", "Prefer keeping files inside this directory")
+	applyCmd.Flags().BoolVar(&assumeSorted, "assume-sorted", false, "Read a results file sorted by hash one group at a time instead of loading it whole; fails if the rows are not sorted")
+
+	rootCmd.AddCommand(applyCmd)
+}
+
+func getAction(deleteFlag bool, hardlinkFlag bool, reflinkFlag bool) (string, error) {
+	var actions []string
+	if deleteFlag {
+		actions = append(actions, actionDelete)
+	}
+	if hardlinkFlag {
+		actions = append(actions, actionHardlink)
+	}
+	if reflinkFlag {
+		actions = append(actions, actionReflink)
+	}
+
+	switch len(actions) {
+	case 0:
+		return "", errors.New("an action is required: use --delete, --hardlink or --reflink")
+	case 1:
+		return actions[0], nil
+	}
+
+	return "", fmt.Errorf("only one of --delete, --hardlink and --reflink can be used, not --%s", strings.Join(actions, " and --"))
+}
+
+// readResultsCsv loads the rows of a results CSV file.
+func readResultsCsv(path string) ([]HashedFileInfo, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open results file: %w", err)
+	}
+	defer file.Close()
+
+	return parseResultsCsv(file, path)
+}
+
+// parseResultsCsv reads all results CSV rows from r. source is only used in
+// error messages.
+func parseResultsCsv(r io.Reader, source string) ([]HashedFileInfo, error) {
+	reader, err := newResultsReader(r, source)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var files []HashedFileInfo
+
+	for {
+		file, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// resultsReader reads the rows of a results CSV one at a time. Columns are
+// located by their header name so the reader does not depend on column
+// order, and columns other than Path and Hash may be missing.
+type resultsReader struct {
+	source       string
+	reader       *csv.Reader
+	decompressed io.Closer
+	columns      map[string]int
+	// record is the row last read.
+	record []string
+}
+
+func newResultsReader(r io.Reader, source string) (*resultsReader, error) {
+	results := &resultsReader{source: source}
+
+	// Gzipped results are recognised by their magic number, whatever they
+	// are called.
+	buffered := bufio.NewReader(r)
+	if magic, _ := buffered.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		decompressed, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress %s: %w", source, err)
+		}
+
+		results.decompressed = decompressed
+		r = decompressed
+	} else {
+		r = buffered
+	}
+
+	results.reader = csv.NewReader(r)
+
+	header, err := results.reader.Read()
+	if err != nil {
+		results.Close()
+		return nil, fmt.Errorf("failed to read header from %s: %w", source, err)
+	}
+
+	// Results written with --csv-bom start with a byte order mark.
+	header[0] = strings.TrimPrefix(header[0], utf8BOM)
+
+	results.columns = make(map[string]int)
+	for i, name := range header {
+		results.columns[name] = i
+	}
+
+	_, hasPath := results.columns["Path"]
+	_, hasHash := results.columns["Hash"]
+	if !hasPath || !hasHash {
+		results.Close()
+		return nil, fmt.Errorf("%s is missing the Path or Hash column", source)
+	}
+
+	return results, nil
+}
+
+// Read returns the next row, or io.EOF after the last one.
+func (r *resultsReader) Read() (HashedFileInfo, error) {
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return HashedFileInfo{}, err
+	}
+	if err != nil {
+		return HashedFileInfo{}, fmt.Errorf("failed to read %s: %w", r.source, err)
+	}
+
+	r.record = record
+	column := r.field
+
+	path, _ := column("Path")
+	hash, _ := column("Hash")
+
+	file := HashedFileInfo{
+		Name: filepath.Base(path),
+		Path: path,
+		Hash: hash,
+	}
+
+	if size, ok := column("Size (bytes)"); ok {
+		file.Size, err = strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return HashedFileInfo{}, fmt.Errorf("invalid size for %s in %s: %w", file.Path, r.source, err)
+		}
+	}
+
+	if modified, ok := column("Modified"); ok {
+		file.ModTime, err = time.Parse(time.RFC3339Nano, modified)
+		if err != nil {
+			return HashedFileInfo{}, fmt.Errorf("invalid modification time for %s in %s: %w", file.Path, r.source, err)
+		}
+	}
+
+	file.RawHash, _ = column("Raw Hash")
+	file.Algorithm, _ = column("Algorithm")
+
+	// Links to another listed file are kept as such, so they are not
+	// mistaken for copies of it.
+	if link, ok := column("Link"); ok {
+		file.Symlink, file.LinkedTo = parseLinkDescription(link)
+	}
+
+	if allocated, ok := column("Allocated (bytes)"); ok && allocated != "" {
+		file.Allocated, err = strconv.ParseInt(allocated, 10, 64)
+		if err != nil {
+			return HashedFileInfo{}, fmt.Errorf("invalid allocated size %q in %s: %w", allocated, r.source, err)
+		}
+		file.Sparse = true
+	}
+
+	return file, nil
+}
+
+// field returns the value of the named column in the row last read, and
+// whether the results have that column.
+func (r *resultsReader) field(name string) (string, bool) {
+	i, ok := r.columns[name]
+	if !ok {
+		return "", false
+	}
+
+	return r.record[i], true
+}
+
+// Close releases the decompressor of gzipped results. The underlying reader
+// is left to the caller.
+func (r *resultsReader) Close() error {
+	if r.decompressed != nil {
+		return r.decompressed.Close()
+	}
+
+	return nil
+}
+
+// checkAlgorithm fails if any of files, read from source, was hashed with
+// another algorithm than algo, since its hash could never match. Results
+// written before the Algorithm column existed cannot be checked.
+func checkAlgorithm(files []HashedFileInfo, source string, algo string) error {
+	for _, file := range files {
+		if file.Algorithm != "" && file.Algorithm != algo {
+			return fmt.Errorf("%s was written with --algo %s, not %s; pass --algo %s to use it", source, file.Algorithm, algo, file.Algorithm)
+		}
+	}
+
+	return nil
+}
+
+// verifyGroups re-hashes every file in the given groups and drops the ones
+// whose content no longer matches the saved hash. Groups left with fewer
+// than two members are dropped as well.
+func verifyGroups(ctx context.Context, groups [][]HashedFileInfo) ([][]HashedFileInfo, error) {
+	var verified [][]HashedFileInfo
+
+	for _, group := range groups {
+		var current []HashedFileInfo
+
+		for _, file := range group {
+			info, err := os.Stat(file.Path)
+			if err != nil {
+				printToStdOut(fmt.Sprintf("Skipping %s: %s\n", file.Path, err))
+				continue
+			}
+
+			hash, err := hashFile(ctx, file.Path, hashOpts, nil)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if err != nil {
+				printToStdOut(fmt.Sprintf("Skipping %s: failed to hash: %s\n", file.Path, err))
+				continue
+			}
+
+			if !hashesMatch(hash, file.Hash) {
+				printToStdOut(fmt.Sprintf("Skipping %s: content changed since the scan\n", file.Path))
+				continue
+			}
+
+			file.Size = info.Size()
+			file.ModTime = info.ModTime()
+			current = append(current, file)
+		}
+
+		if len(current) > 1 {
+			verified = append(verified, current)
+		}
+	}
+
+	return verified, nil
+}
+
+// applySorted applies action to the duplicates in the results file at path,
+// which must be sorted by hash. Only the rows sharing one hash are held in
+// memory at a time, so the size of the file does not matter.
+func applySorted(ctx context.Context, path string, action string) error {
+	// Confirming needs the totals before anything is changed, so the file
+	// is read twice: first to add up the saved rows, then to act.
+	if !applyDryRun {
+		var planned applyTotals
+
+		err := forEachSortedRun(path, func(run []HashedFileInfo) error {
+			planned.add(plannedTotals(filterBySavings(findDuplicateGroups(run, 2, matchOn), int64(minSavings))))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = confirmApply(action, planned, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	var totals applyTotals
+
+	err := forEachSortedRun(path, func(run []HashedFileInfo) error {
+		groups, err := verifyGroups(ctx, findDuplicateGroups(run, 2, matchOn))
+		if err != nil {
+			return err
+		}
+
+		for _, group := range filterBySavings(groups, int64(minSavings)) {
+			err = applyToGroup(group, action, applyDryRun, &totals)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	totals.report(action, applyDryRun)
+
+	return totals.err(action)
+}
+
+// forEachSortedRun opens the results file at path and calls fn with each
+// run of rows sharing a hash, as forEachHashRun does.
+func forEachSortedRun(path string, fn func(run []HashedFileInfo) error) error {
+	path, err := expandHome(path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open results file: %w", err)
+	}
+	defer file.Close()
+
+	reader, err := newResultsReader(file, path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return forEachHashRun(reader, path, fn)
+}
+
+// forEachHashRun reads the rows of a results file sorted by hash and calls
+// fn with each run of consecutive rows sharing a hash. It fails on the
+// first row that is out of order, since a run split in two would hide
+// duplicates.
+func forEachHashRun(reader *resultsReader, source string, fn func(run []HashedFileInfo) error) error {
+	var run []HashedFileInfo
+
+	for row := 1; ; row++ {
+		file, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		err = checkAlgorithm([]HashedFileInfo{file}, source, hashOpts.algo)
+		if err != nil {
+			return err
+		}
+
+		if len(run) > 0 && file.Hash != run[0].Hash {
+			if file.Hash < run[0].Hash {
+				return fmt.Errorf("%s is not sorted by hash: row %d (%s) comes after hash %s; sort the file or drop --assume-sorted", source, row, file.Path, run[0].Hash)
+			}
+
+			err = fn(run)
+			if err != nil {
+				return err
+			}
+
+			run = nil
+		}
+
+		run = append(run, file)
+	}
+
+	if len(run) > 0 {
+		return fn(run)
+	}
+
+	return nil
+}
+
+// applyTotals counts the files an apply run acted on and the space freed.
+type applyTotals struct {
+	files     int
+	groups    int
+	reclaimed int64
+	// failed counts the files an action failed on, which apply reports
+	// and carries on past.
+	failed int
+}
+
+func (t *applyTotals) add(other applyTotals) {
+	t.files += other.files
+	t.groups += other.groups
+	t.reclaimed += other.reclaimed
+	t.failed += other.failed
+}
+
+// plannedTotals adds up the redundant copies in groups, which apply acts on
+// unless an action fails.
+func plannedTotals(groups [][]HashedFileInfo) applyTotals {
+	var totals applyTotals
+
+	for _, group := range groups {
+		keepers := selectKeepers(group, keepStrategy, canonicalDir)
+
+		before := totals.files
+		for i, file := range group {
+			if keepers[i] != i {
+				totals.files++
+				totals.reclaimed += diskSize(file)
+			}
+		}
+
+		if totals.files > before {
+			totals.groups++
+		}
+	}
+
+	return totals
+}
+
+// stdinIsTerminal reports whether standard input can be used to ask the
+// user. /dev/null is a character device too, so it is ruled out by name.
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
+
+// actionVerb names action as the verb of a sentence about files.
+func actionVerb(action string) string {
+	switch action {
+	case actionHardlink:
+		return "hard link"
+	case actionReflink:
+		return "reflink"
+	}
+
+	return "delete"
+}
+
+// confirmApply prints what apply is about to do and asks for confirmation
+// on the terminal unless --yes was given. Without a terminal to ask on it
+// refuses to go ahead. estimated marks totals taken from the saved rows
+// before the files were re-hashed, so changed files can still drop out.
+func confirmApply(action string, totals applyTotals, estimated bool) error {
+	if totals.files == 0 {
+		return nil
+	}
+
+	count := strconv.Itoa(totals.files)
+	if estimated {
+		count = "up to " + count
+	}
+
+	// The summary goes to stderr so it is shown even with --quiet or when
+	// stdout is redirected.
+	fmt.Fprintf(os.Stderr, "About to %s %s files, reclaiming %s across %d groups\n", actionVerb(action), count, formatBytes(totals.reclaimed), totals.groups)
+
+	if applyYes {
+		return nil
+	}
+
+	if !stdinIsTerminal() {
+		return errors.New("not running interactively, so nothing was changed; add --yes to proceed")
+	}
+
+	fmt.Fprint(os.Stderr, "Proceed? [y/N] ")
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+
+	return errors.New("aborted, nothing was changed; add --yes to proceed without asking")
+}
+
+func (t applyTotals) report(action string, dryRun bool) {
+	if dryRun {
+		printToStdOut(fmt.Sprintf("Dry run: %d files would be affected, reclaiming %s\n", t.files, formatBytes(t.reclaimed)))
+	} else {
+		printToStdOut(fmt.Sprintf("%s %d files, reclaiming %s\n", actionPastTense(action), t.files, formatBytes(t.reclaimed)))
+	}
+}
+
+// err returns an error counting the files action failed on, or nil if it
+// failed on none. Each failure has already been printed as it happened.
+func (t applyTotals) err(action string) error {
+	if t.failed == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("failed to %s %d of %d files", actionVerb(action), t.failed, t.files+t.failed)
+}
+
+func applyToGroups(groups [][]HashedFileInfo, action string, dryRun bool) error {
+	var totals applyTotals
+
+	for _, group := range groups {
+		err := applyToGroup(group, action, dryRun, &totals)
+		if err != nil {
+			totals.report(action, dryRun)
+			return err
+		}
+	}
+
+	totals.report(action, dryRun)
+
+	return totals.err(action)
+}
+
+// applyToGroup applies action to the redundant copies in group and adds
+// them to totals. With --safe-mode it fails, before changing anything in
+// the group, if the selected keepers would not leave a copy behind.
+func applyToGroup(group []HashedFileInfo, action string, dryRun bool, totals *applyTotals) error {
+	keepers := selectKeepers(group, keepStrategy, canonicalDir)
+
+	if safeMode {
+		err := checkKeepsCopy(group, keepers)
+		if err != nil {
+			return fmt.Errorf("safe mode stopped apply, nothing more was changed: %w", err)
+		}
+	}
+
+	for i, duplicate := range group {
+		if keepers[i] == i {
+			continue
+		}
+
+		keeper := group[keepers[i]]
+
+		if safeMode {
+			err := checkKeeper(keeper)
+			if err != nil {
+				printToStdErr(fmt.Errorf("safe mode skipped %s: %w", duplicate.Path, err))
+				totals.failed++
+				continue
+			}
+		}
+
+		if dryRun {
+			printToStdOut(fmt.Sprintf("Would %s: %s (keeping %s)\n", action, duplicate.Path, keeper.Path))
+		} else {
+			err := applyAction(action, keeper, duplicate)
+			if errors.Is(err, errReflinkUnsupported) {
+				printWarning(fmt.Sprintf("skipped %s, its file system does not support reflinks", duplicate.Path))
+				continue
+			}
+			if err != nil {
+				printToStdErr(err)
+				totals.failed++
+				continue
+			}
+			printToStdOut(fmt.Sprintf("%s: %s (kept %s)\n", actionPastTense(action), duplicate.Path, keeper.Path))
+		}
+
+		totals.files++
+		totals.reclaimed += diskSize(duplicate)
+	}
+
+	return nil
+}
+
+// checkKeepsCopy makes sure that acting on group as keepers says leaves at
+// least one of its files in place: some file must be kept, every copy must
+// be replaced by a kept file, and no kept path may also be acted on. The
+// keep strategies never break this; the check is there in case a bug or a
+// malformed results file would.
+func checkKeepsCopy(group []HashedFileInfo, keepers []int) error {
+	kept := make(map[string]bool)
+	for i, keeper := range keepers {
+		if keeper == i {
+			kept[filepath.Clean(group[i].Path)] = true
+		}
+	}
+
+	if len(kept) == 0 {
+		return fmt.Errorf("no file of the group with hash %s would be kept", group[0].Hash)
+	}
+
+	for i, keeper := range keepers {
+		if keeper == i {
+			continue
+		}
+
+		if keepers[keeper] != keeper {
+			return fmt.Errorf("%s would be replaced by %s, which is not kept either", group[i].Path, group[keeper].Path)
+		}
+
+		if kept[filepath.Clean(group[i].Path)] {
+			return fmt.Errorf("%s is listed both as the kept file and as a copy", group[i].Path)
+		}
+	}
+
+	return nil
+}
+
+// checkKeeper makes sure the kept file is still a regular file. A symlink
+// kept in place of the copies would leave the content in a file outside
+// the group, or nowhere once the copies are gone.
+func checkKeeper(keeper HashedFileInfo) error {
+	info, err := os.Lstat(keeper.Path)
+	if err != nil {
+		return fmt.Errorf("the kept file %s is gone: %w", keeper.Path, err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("the kept file %s is not a regular file", keeper.Path)
+	}
+
+	return nil
+}
+
+func actionPastTense(action string) string {
+	switch action {
+	case actionHardlink:
+		return "Hard linked"
+	case actionReflink:
+		return "Reflinked"
+	}
+
+	return "Deleted"
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// scanToCsv scans dir as a run without flags would and writes the results
+// to a CSV file, returning its path.
+func scanToCsv(t *testing.T, dir string) string {
+	t.Helper()
+
+	files, err := processFiles(context.Background(), []string{dir}, testScanOptions())
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "results.csv")
+	err = writeToCsv(files, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filename
+}
+
+// applyCsv applies action to the duplicates in the results file at path as
+// apply --yes does.
+func applyCsv(t *testing.T, path string, action string) error {
+	t.Helper()
+
+	files, err := readResultsCsv(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := verifyGroups(context.Background(), findDuplicateGroups(files, 2, matchHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return applyToGroups(groups, action, false)
+}
+
+// readFiles returns the content of each of names in dir, or "" for the
+// ones that do not exist.
+func readFiles(t *testing.T, dir string, names ...string) map[string]string {
+	t.Helper()
+
+	contents := make(map[string]string)
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+
+		contents[name] = string(data)
+	}
+
+	return contents
+}
+
+func TestApplyDeleteRehashesBeforeDeleting(t *testing.T) {
+	quietOutput(t)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "same", "b.txt": "same", "c.txt": "same"})
+
+	results := scanToCsv(t, dir)
+
+	// c.txt no longer matches the saved hash, so it must be left alone.
+	writeFiles(t, dir, map[string]string{"c.txt": "edited after the scan"})
+
+	err := applyCsv(t, results, actionDelete)
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+
+	got := readFiles(t, dir, "a.txt", "b.txt", "c.txt")
+	want := map[string]string{"a.txt": "same", "b.txt": "", "c.txt": "edited after the scan"}
+	for name := range want {
+		if got[name] != want[name] {
+			t.Errorf("%s contains %q after apply, want %q", name, got[name], want[name])
+		}
+	}
+}
+
+func TestApplyHardlinkTwiceLeavesLinkedFiles(t *testing.T) {
+	quietOutput(t)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "same", "b.txt": "same"})
+
+	results := scanToCsv(t, dir)
+
+	err := applyCsv(t, results, actionHardlink)
+	if err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+
+	a, err := os.Stat(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.Stat(filepath.Join(dir, "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(a, b) {
+		t.Fatal("b.txt is not a hard link to a.txt after apply --hardlink")
+	}
+
+	// The saved results still list both paths, which now are one file.
+	// Applying them again must refuse rather than remove the only copy.
+	errors := captureStderr(t, func() {
+		err = applyCsv(t, results, actionDelete)
+	})
+	if err == nil || !strings.Contains(err.Error(), "failed to delete 1 of 1 files") {
+		t.Errorf("second apply returned %v, want it to fail on the linked file", err)
+	}
+	if !strings.Contains(errors, "is already the same file as") {
+		t.Errorf("second apply did not report the linked file:\n%s", errors)
+	}
+
+	got := readFiles(t, dir, "a.txt", "b.txt")
+	for name, content := range got {
+		if content != "same" {
+			t.Errorf("%s contains %q after the second apply, want %q", name, content, "same")
+		}
+	}
+}
+
+func TestApplyReflinkKeepsFilesIndependent(t *testing.T) {
+	quietOutput(t)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "same", "b.txt": "same"})
+
+	results := scanToCsv(t, dir)
+
+	// On a file system without reflinks the copy is skipped with a
+	// warning, which must leave it as it was.
+	captureStderr(t, func() {
+		err := applyCsv(t, results, actionReflink)
+		if err != nil {
+			t.Errorf("apply failed: %v", err)
+		}
+	})
+
+	a, err := os.Stat(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.Stat(filepath.Join(dir, "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(a, b) {
+		t.Fatal("b.txt became a hard link to a.txt, want an independent file")
+	}
+
+	writeFiles(t, dir, map[string]string{"b.txt": "changed"})
+
+	if got := readFiles(t, dir, "a.txt")["a.txt"]; got != "same" {
+		t.Errorf("writing to b.txt changed a.txt to %q", got)
+	}
+}
+
+func TestSafeModeStopsWhenNoCopyWouldBeKept(t *testing.T) {
+	quietOutput(t)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "same", "b.txt": "same"})
+
+	results := scanToCsv(t, dir)
+
+	// A results file listing a.txt twice makes it both the kept file and
+	// a copy to delete.
+	data, err := os.ReadFile(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	err = os.WriteFile(results, []byte(lines[0]+lines[1]+lines[1]+strings.Join(lines[2:], "")), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = applyCsv(t, results, actionDelete)
+	if err == nil || !strings.Contains(err.Error(), "safe mode stopped apply") {
+		t.Errorf("apply returned %v, want safe mode to stop it", err)
+	}
+
+	got := readFiles(t, dir, "a.txt", "b.txt")
+	for name, content := range got {
+		if content != "same" {
+			t.Errorf("%s contains %q after apply, want it untouched", name, content)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// archivePathSeparator joins an archive's path with the path of an entry
+// inside it, e.g. photos.zip!/2024/img.jpg. The same form is used for every
+// archive format and repeats for nested archives.
+const archivePathSeparator = "!/"
+
+var errArchiveTooLarge = errors.New("archive exceeds --archive-max-bytes")
+
+type archiveOptions struct {
+	enabled  bool
+	maxDepth int
+	maxBytes int64
+}
+
+func archiveFormat(name string) string {
+	lower := strings.ToLower(name)
+
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz"
+	}
+
+	return ""
+}
+
+func isArchive(name string) bool {
+	return archiveFormat(name) != ""
+}
+
+// archiveScanner hashes the entries of one top-level archive and everything
+// nested inside it, sharing a single uncompressed byte budget so that a
+// decompression bomb cannot be hidden behind several layers of archives.
+type archiveScanner struct {
+	ctx       context.Context
+	opts      scanOptions
+	maxDepth  int
+	remaining int64
+	files     []HashedFileInfo
+}
+
+// scanArchive returns the hashed entries of the archive at archivePath that
+// are selected by opts. When the byte budget is exceeded no entries are
+// returned.
+func scanArchive(ctx context.Context, archivePath string, opts scanOptions) ([]HashedFileInfo, error) {
+	acquireFile()
+	defer releaseFile()
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := &archiveScanner{
+		ctx:       ctx,
+		opts:      opts,
+		maxDepth:  opts.archives.maxDepth,
+		remaining: opts.archives.maxBytes,
+	}
+
+	err = scanner.scan(file, info.Size(), archiveFormat(archivePath), archivePath, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanner.files, nil
+}
+
+func (s *archiveScanner) scan(r io.ReaderAt, size int64, format string, virtualPath string, depth int) error {
+	switch format {
+	case "zip":
+		return s.scanZip(r, size, virtualPath, depth)
+	case "tar":
+		return s.scanTar(io.NewSectionReader(r, 0, size), virtualPath, depth)
+	case "tar.gz":
+		gz, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+
+		return s.scanTar(gz, virtualPath, depth)
+	}
+
+	return fmt.Errorf("unsupported archive format: %s", virtualPath)
+}
+
+func (s *archiveScanner) scanZip(r io.ReaderAt, size int64, virtualPath string, depth int) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range zr.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+
+		rc, err := entry.Open()
+		if err != nil {
+			return err
+		}
+
+		err = s.addEntry(rc, entry.FileInfo(), entry.Name, virtualPath, depth)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *archiveScanner) scanTar(r io.Reader, virtualPath string, depth int) error {
+	tr := tar.NewReader(r)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		err = s.addEntry(tr, header.FileInfo(), header.Name, virtualPath, depth)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *archiveScanner) addEntry(r io.Reader, info os.FileInfo, name string, parent string, depth int) error {
+	virtualPath := parent + archivePathSeparator + strings.TrimPrefix(name, "/")
+	if isSkipped(virtualPath, s.opts) {
+		printVerbose(fmt.Sprintf("Skipped: %s (matches --skip-files-matching)\n", virtualPath))
+		return nil
+	}
+
+	matches := matchesExtension(name, s.opts.exts)
+	nested := depth < s.maxDepth && isArchive(name)
+
+	if !matches && !nested {
+		printVerbose(fmt.Sprintf("Skipped: %s (extension not selected by --ext)\n", virtualPath))
+		return nil
+	}
+
+	if !nested && info.Size() == 0 && s.opts.emptyFiles == emptySkip {
+		printVerbose(fmt.Sprintf("Skipped: %s (empty file, use --include-zero-size-as-unique or --group-zero-size to keep it)\n", virtualPath))
+		return nil
+	}
+
+	limited := &budgetReader{r: r, remaining: &s.remaining}
+
+	fileInfo := HashedFileInfo{
+		Name:    path.Base(name),
+		Path:    virtualPath,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+
+	if !nested {
+		err := s.hashEntry(&fileInfo, limited, info.Mode())
+		if err != nil {
+			return err
+		}
+
+		s.files = append(s.files, fileInfo)
+
+		return nil
+	}
+
+	// Nested archives have to be buffered since zip needs random access.
+	// The buffer is filled through the byte budget, so its size is bounded.
+	content, err := io.ReadAll(&contextReader{ctx: s.ctx, r: limited})
+	if err != nil {
+		return err
+	}
+
+	if matches {
+		err = s.hashEntry(&fileInfo, bytes.NewReader(content), info.Mode())
+		if err != nil {
+			return err
+		}
+
+		s.files = append(s.files, fileInfo)
+	}
+
+	return s.scan(bytes.NewReader(content), int64(len(content)), archiveFormat(name), virtualPath, depth+1)
+}
+
+// hashEntry hashes the entry read from r as a file of the same name on disk
+// would be: a compressed entry gets both hashes with --decompress-compare,
+// and an entry with a normalizer for its extension is normalized. Both read
+// the content more than once or out of order, so such an entry is buffered
+// first, which the byte budget bounds as for nested archives.
+func (s *archiveScanner) hashEntry(fileInfo *HashedFileInfo, r io.Reader, mode os.FileMode) error {
+	opts := s.opts.hash
+	ext := strings.ToLower(path.Ext(fileInfo.Name))
+
+	decompress, compressed := decompressors[ext]
+	compressed = compressed && opts.decompress
+	normalizer, normalized := opts.normalizers[ext]
+
+	if !compressed && !normalized {
+		hash, err := hashReader(s.ctx, r, fileInfo.Name, mode, opts)
+		if err != nil {
+			return err
+		}
+
+		fileInfo.Hash = hash
+		return nil
+	}
+
+	content, err := io.ReadAll(&contextReader{ctx: s.ctx, r: r})
+	if err != nil {
+		return err
+	}
+
+	if !compressed {
+		normalizedContent, err := normalizer.normalize(bytes.NewReader(content), int64(len(content)))
+		if err != nil {
+			return fmt.Errorf("failed to normalize %s: %w", fileInfo.Path, err)
+		}
+
+		hash, err := hashReader(s.ctx, normalizedContent, fileInfo.Name, mode, opts)
+		if err != nil {
+			return err
+		}
+
+		fileInfo.Hash = hash
+		return nil
+	}
+
+	rawHash, err := hashReader(s.ctx, bytes.NewReader(content), fileInfo.Name, mode, opts)
+	if err != nil {
+		return err
+	}
+
+	// The decompressed content counts against the byte budget too, so a
+	// small compressed entry cannot expand without bound.
+	decompressed, err := decompress(bytes.NewReader(content))
+	if err == nil {
+		defer decompressed.Close()
+
+		var hash string
+		hash, err = hashReader(s.ctx, &budgetReader{r: decompressed, remaining: &s.remaining}, fileInfo.Name, mode, opts)
+		if err == nil {
+			fileInfo.Hash = hash
+			fileInfo.RawHash = rawHash
+			return nil
+		}
+	}
+
+	if s.ctx.Err() != nil || errors.Is(err, errArchiveTooLarge) {
+		return err
+	}
+
+	// As with a compressed file on disk, an entry that cannot be
+	// decompressed is compared by its raw bytes.
+	printWarning(fmt.Sprintf("comparing %s by its raw bytes: %s", fileInfo.Path, err))
+	fileInfo.Hash = rawHash
+
+	return nil
+}
+
+// budgetReader fails once more than the remaining uncompressed byte budget
+// has been read through it.
+type budgetReader struct {
+	r         io.Reader
+	remaining *int64
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	*b.remaining -= int64(n)
+
+	if *b.remaining < 0 {
+		return n, errArchiveTooLarge
+	}
+
+	return n, err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestArchiveEntriesAreNormalizedAndDecompressed(t *testing.T) {
+	quietOutput(t)
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte("data"))
+	gz.Close()
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for name, content := range map[string]string{
+		"windows.txt": "one\r\ntwo\r\n",
+		"data.gz":     compressed.String(),
+		"broken.gz":   "not gzip",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	zw.Close()
+
+	// An entry is hashed as the same file on disk would be, so it matches
+	// the files outside the archive only when normalized or decompressed.
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"unix.txt":      "one\ntwo\n",
+		"data":          "data",
+		"broken-raw.gz": "not gzip",
+	})
+	err := os.WriteFile(filepath.Join(dir, "archive.zip"), archive.Bytes(), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	normalizers, err := buildNormalizers(nil, []string{".txt"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := testScanOptions()
+	opts.hash.normalizers = normalizers
+	opts.hash.decompress = true
+	opts.archives = archiveOptions{enabled: true, maxDepth: 1, maxBytes: 1 << 20}
+
+	var groups [][]string
+	captureStderr(t, func() {
+		groups = scanGroups(t, dir, opts)
+	})
+
+	want := [][]string{
+		{"broken-raw.gz", "broken.gz"},
+		{"data", "data.gz"},
+		{"unix.txt", "windows.txt"},
+	}
+	if !slices.EqualFunc(groups, want, slices.Equal) {
+		t.Errorf("duplicate groups are %v, want %v", groups, want)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var autoWorkers bool
+
+func init() {
+	rootCmd.Flags().BoolVar(&autoWorkers, "auto-workers", false, "Tune the number of files hashed concurrently to the throughput of the storage; --workers overrides it")
+}
+
+const (
+	// autoWorkersStart is the pool size an --auto-workers scan starts with.
+	autoWorkersStart = 2
+	// maxAutoWorkers caps the pool, further limited by --max-open-files.
+	maxAutoWorkers = 64
+	// autoWorkersInterval is how long each pool size is measured for.
+	autoWorkersInterval = 2 * time.Second
+	// autoWorkersGain is how much faster a larger pool has to read for it
+	// to be kept; less than that counts as a plateau.
+	autoWorkersGain = 1.1
+)
+
+// workerTuning is the outcome of tuning a pool with runAutoWorkers.
+type workerTuning struct {
+	workers int
+	rate    float64
+	settled bool
+}
+
+// runAutoWorkers works like runWorkers, but starts with a small pool and
+// doubles it for as long as that makes progress read faster. Once a larger
+// pool brings no gain, or makes things slower as seeking does on a hard
+// disk, it goes back to the best size found and stays there.
+func runAutoWorkers(ctx context.Context, n int, progress *progressTracker, fn func(i int) error) error {
+	limit := maxAutoWorkers
+	if openFiles != nil {
+		limit = min(limit, cap(openFiles))
+	}
+	start := min(autoWorkersStart, limit)
+
+	// A worker holds a slot while it runs fn. The slots the tuner holds
+	// itself are the ones the pool may not use at its current size.
+	slots := make(chan struct{}, limit)
+	for i := start; i < limit; i++ {
+		slots <- struct{}{}
+	}
+
+	tuneCtx, stopTuning := context.WithCancel(ctx)
+	tuned := make(chan workerTuning, 1)
+	go func() {
+		tuned <- tuneWorkers(tuneCtx, slots, start, limit, progress)
+	}()
+
+	err := runWorkers(ctx, n, limit, func(i int) error {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		defer func() { <-slots }()
+
+		return fn(i)
+	})
+
+	stopTuning()
+	tuning := <-tuned
+
+	switch {
+	case tuning.settled:
+		printToStdOut(fmt.Sprintf("Settled on %d workers (%s/s)\n", tuning.workers, formatBytes(int64(tuning.rate))))
+	case n > 0:
+		printToStdOut(fmt.Sprintf("Hashing ended before --auto-workers settled, last with %d workers\n", tuning.workers))
+	}
+
+	return err
+}
+
+// tuneWorkers resizes the pool sharing slots every autoWorkersInterval
+// until it settles or ctx is done. Intervals in which nothing was read,
+// such as while cached hashes are reused, are not measured.
+func tuneWorkers(ctx context.Context, slots chan struct{}, size int, limit int, progress *progressTracker) workerTuning {
+	ticker := time.NewTicker(autoWorkersInterval)
+	defer ticker.Stop()
+
+	best := workerTuning{workers: size}
+	last := progress.bytesRead()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return workerTuning{workers: size}
+		}
+
+		read := progress.bytesRead()
+		if read == last {
+			continue
+		}
+
+		rate := float64(read-last) / autoWorkersInterval.Seconds()
+		last = read
+
+		if rate > best.rate*autoWorkersGain {
+			best = workerTuning{workers: size, rate: rate}
+			if size == limit {
+				best.settled = true
+				return best
+			}
+
+			next := min(size*2, limit)
+			printVerbose(fmt.Sprintf("Read %s/s with %d workers, trying %d\n", formatBytes(int64(rate)), size, next))
+
+			// The tuner holds at least limit-size slots, so giving some
+			// back never blocks.
+			for ; size < next; size++ {
+				<-slots
+			}
+
+			continue
+		}
+
+		printVerbose(fmt.Sprintf("Read %s/s with %d workers, going back to %d\n", formatBytes(int64(rate)), size, best.workers))
+
+		// Taking slots back waits for running workers to finish.
+		for ; size > best.workers; size-- {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return workerTuning{workers: size}
+			}
+		}
+
+		best.settled = true
+		return best
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheVersion is the version of the --cache-file format.
+const cacheVersion = 1
+
+// cacheKey identifies the single entry kept per file and hash settings.
+// The size and modification time are checked on lookup, so a changed file
+// replaces its old entry rather than adding another.
+type cacheKey struct {
+	path     string
+	settings string
+}
+
+type cacheEntry struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Settings string    `json:"settings"`
+	Hash     string    `json:"hash"`
+	RawHash  string    `json:"raw_hash,omitempty"`
+}
+
+func (e cacheEntry) key() cacheKey {
+	return cacheKey{path: e.Path, settings: e.Settings}
+}
+
+type cacheFile struct {
+	Version int `json:"version"`
+	// LastRuns records when the last complete --since-last-run scan of
+	// each set of directories and hash settings started.
+	LastRuns map[string]time.Time `json:"last_runs,omitempty"`
+	Entries  []cacheEntry         `json:"entries"`
+}
+
+// hashCache is a hash cache shared between scans, stored in a single file.
+// Entries are keyed by absolute path, size, modification time and the hash
+// settings, so a file is only re-hashed once one of those changes. It is
+// safe for concurrent use.
+type hashCache struct {
+	path     string
+	settings string
+
+	mu         sync.Mutex
+	entries    map[cacheKey]cacheEntry
+	updates    []cacheEntry
+	lastRuns   map[string]time.Time
+	runUpdates map[string]time.Time
+}
+
+// loadHashCache opens the cache at path. A missing file is an empty cache.
+func loadHashCache(path string, opts hashOptions) (*hashCache, error) {
+	entries, lastRuns, err := readCacheFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hashCache{
+		path:       path,
+		settings:   opts.fingerprint(),
+		entries:    entries,
+		lastRuns:   lastRuns,
+		runUpdates: make(map[string]time.Time),
+	}, nil
+}
+
+func readCacheFile(path string) (map[cacheKey]cacheEntry, map[string]time.Time, error) {
+	entries := make(map[cacheKey]cacheEntry)
+	lastRuns := make(map[string]time.Time)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return entries, lastRuns, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read cache file: %w", err)
+	}
+
+	var cache cacheFile
+	err = json.Unmarshal(data, &cache)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse cache file %s: %w", path, err)
+	}
+
+	if cache.Version != cacheVersion {
+		return nil, nil, fmt.Errorf("cache file %s has unsupported version %d", path, cache.Version)
+	}
+
+	for _, entry := range cache.Entries {
+		entries[entry.key()] = entry
+	}
+
+	for key, started := range cache.LastRuns {
+		lastRuns[key] = started
+	}
+
+	return entries, lastRuns, nil
+}
+
+func (c *hashCache) entryFor(file HashedFileInfo) (cacheEntry, bool) {
+	absPath, err := filepath.Abs(file.Path)
+	if err != nil {
+		return cacheEntry{}, false
+	}
+
+	return cacheEntry{
+		Path:     absPath,
+		Size:     file.Size,
+		Modified: file.ModTime,
+		Settings: c.settings,
+	}, true
+}
+
+// lookup returns the cached hashes of file, if any.
+func (c *hashCache) lookup(file HashedFileInfo) (cacheEntry, bool) {
+	entry, ok := c.entryFor(file)
+	if !ok {
+		return cacheEntry{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.entries[entry.key()]
+	if !ok || cached.Size != entry.Size || !cached.Modified.Equal(entry.Modified) {
+		return cacheEntry{}, false
+	}
+
+	return cached, true
+}
+
+// store records the hashes of a freshly hashed file.
+func (c *hashCache) store(file HashedFileInfo) {
+	entry, ok := c.entryFor(file)
+	if !ok {
+		return
+	}
+
+	entry.Hash = file.Hash
+	entry.RawHash = file.RawHash
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[entry.key()] = entry
+	c.updates = append(c.updates, entry)
+}
+
+// runKey identifies a scan of folderPaths with the cache's hash settings.
+func (c *hashCache) runKey(folderPaths []string) string {
+	roots := make([]string, 0, len(folderPaths))
+	for _, folderPath := range folderPaths {
+		absPath, err := filepath.Abs(folderPath)
+		if err != nil {
+			absPath = folderPath
+		}
+		roots = append(roots, absPath)
+	}
+	sort.Strings(roots)
+
+	return c.settings + ";roots=" + strings.Join(roots, string(os.PathListSeparator))
+}
+
+// lastRun returns when the last complete scan of folderPaths recorded with
+// recordRun started.
+func (c *hashCache) lastRun(folderPaths []string) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	started, ok := c.lastRuns[c.runKey(folderPaths)]
+	return started, ok
+}
+
+// recordRun records that a complete scan of folderPaths started at started.
+func (c *hashCache) recordRun(folderPaths []string, started time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := c.runKey(folderPaths)
+	c.lastRuns[key] = started
+	c.runUpdates[key] = started
+}
+
+// save merges the entries stored during this scan into the cache file.
+// The file is re-read under a lock first so entries saved by other scans in
+// the meantime are kept, and replaced atomically so readers never see a
+// partly written file.
+func (c *hashCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.updates) == 0 && len(c.runUpdates) == 0 {
+		return nil
+	}
+
+	dir := filepath.Dir(c.path)
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	lock, err := os.OpenFile(c.path+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open cache lock file: %w", err)
+	}
+	defer lock.Close()
+
+	err = lockFile(lock)
+	if err != nil {
+		return fmt.Errorf("failed to lock cache file: %w", err)
+	}
+	defer unlockFile(lock)
+
+	entries, lastRuns, err := readCacheFile(c.path)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range c.updates {
+		entries[entry.key()] = entry
+	}
+
+	for key, started := range c.runUpdates {
+		lastRuns[key] = started
+	}
+
+	cache := cacheFile{Version: cacheVersion, LastRuns: lastRuns, Entries: make([]cacheEntry, 0, len(entries))}
+	for _, entry := range entries {
+		cache.Entries = append(cache.Entries, entry)
+	}
+
+	sort.Slice(cache.Entries, func(i, j int) bool {
+		if cache.Entries[i].Path != cache.Entries[j].Path {
+			return cache.Entries[i].Path < cache.Entries[j].Path
+		}
+		return cache.Entries[i].Settings < cache.Entries[j].Settings
+	})
+
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache file: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(c.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+
+	_, err = tmp.Write(data)
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+
+	c.updates = nil
+	c.runUpdates = make(map[string]time.Time)
+
+	return nil
+}
+
+// fingerprint describes every setting that affects a file's hash, so cache
+// entries are only reused by scans that would compute the same hash.
+func (o hashOptions) fingerprint() string {
+	exts := make([]string, 0, len(o.normalizers))
+	for ext, normalizer := range o.normalizers {
+		exts = append(exts, fmt.Sprintf("%s=%#v", ext, normalizer))
+	}
+	sort.Strings(exts)
+
+	return fmt.Sprintf("algo=%s;length=%d;name=%t;mode=%t;decompress=%t;normalize=%s",
+		o.algo, o.length, o.includeName, o.includeMode, o.decompress, strings.Join(exts, ","))
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpointInterval is how many bytes of a file are hashed between two
+// checkpoints. Files smaller than that never get one.
+const checkpointInterval = 256 * 1024 * 1024
+
+// hashCheckpoint is the saved state of a partly hashed file, written to the
+// --checkpoint-dir as JSON.
+type hashCheckpoint struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	Modified  time.Time `json:"modified"`
+	Algorithm string    `json:"algorithm"`
+	Offset    int64     `json:"offset"`
+	State     []byte    `json:"state"`
+}
+
+// checkpointable reports whether the state of hash can be saved and
+// restored. The standard md5, sha1, sha256 and sha512 and xxh64 support it;
+// blake3, xxh128 and the tree algorithms do not.
+func checkpointable(hash hash.Hash) bool {
+	_, marshals := hash.(encoding.BinaryMarshaler)
+	_, unmarshals := hash.(encoding.BinaryUnmarshaler)
+
+	return marshals && unmarshals
+}
+
+// checkpointPath returns where the checkpoint of the file at absPath is
+// kept.
+func checkpointPath(dir string, absPath string) string {
+	sum := sha256.Sum256([]byte(absPath))
+
+	return filepath.Join(dir, fmt.Sprintf("%x.json", sum[:8]))
+}
+
+// checkpointer saves the state of hash as the file at path is read, and
+// restores an earlier one for the same file.
+type checkpointer struct {
+	file string
+	path string
+	// absPath identifies the file in the checkpoint, so a scan started
+	// from another directory still finds it.
+	absPath  string
+	info     fs.FileInfo
+	algo     string
+	hash     hash.Hash
+	offset   int64
+	next     int64
+	disabled bool
+}
+
+func newCheckpointer(dir string, path string, info fs.FileInfo, algo string, hash hash.Hash) *checkpointer {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		absPath = path
+	}
+
+	return &checkpointer{
+		file:    checkpointPath(dir, absPath),
+		path:    path,
+		absPath: absPath,
+		info:    info,
+		algo:    algo,
+		hash:    hash,
+		next:    checkpointInterval,
+	}
+}
+
+// resume restores a checkpoint saved for this file, as long as the file is
+// unchanged since, and returns the offset to go on reading from. It returns
+// 0 when there is nothing to resume from.
+func (c *checkpointer) resume() int64 {
+	data, err := os.ReadFile(c.file)
+	if err != nil {
+		return 0
+	}
+
+	var saved hashCheckpoint
+	err = json.Unmarshal(data, &saved)
+	if err != nil || saved.Path != c.absPath || saved.Size != c.info.Size() || !saved.Modified.Equal(c.info.ModTime()) || saved.Algorithm != c.algo {
+		return 0
+	}
+
+	err = c.hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(saved.State)
+	if err != nil {
+		c.hash.Reset()
+		return 0
+	}
+
+	c.offset = saved.Offset
+	c.next = saved.Offset + checkpointInterval
+
+	return saved.Offset
+}
+
+// reader returns r, which must continue from the current offset, wrapped so
+// that a checkpoint is saved every checkpointInterval bytes.
+func (c *checkpointer) reader(r io.Reader) io.Reader {
+	return &checkpointReader{r: r, c: c}
+}
+
+// save writes the state of the hash, which has taken in exactly c.offset
+// bytes, under a temporary name first so a crash never leaves half a
+// checkpoint. A checkpoint that cannot be written only costs the ability
+// to resume, so the hashing carries on without further checkpoints.
+func (c *checkpointer) save() {
+	state, err := c.hash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err == nil {
+		var data []byte
+		data, err = json.Marshal(hashCheckpoint{
+			Path:      c.absPath,
+			Size:      c.info.Size(),
+			Modified:  c.info.ModTime(),
+			Algorithm: c.algo,
+			Offset:    c.offset,
+			State:     state,
+		})
+		if err == nil {
+			err = os.WriteFile(c.file+".tmp", data, 0o644)
+		}
+		if err == nil {
+			err = os.Rename(c.file+".tmp", c.file)
+		}
+	}
+
+	if err != nil {
+		printWarning(fmt.Sprintf("failed to save a hash checkpoint for %s, hashing it without: %s", c.path, err))
+		c.disabled = true
+	}
+}
+
+// finish removes the checkpoint once the file has been hashed to the end.
+func (c *checkpointer) finish() {
+	err := os.Remove(c.file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		printWarning(fmt.Sprintf("failed to remove the hash checkpoint of %s: %s", c.path, err))
+	}
+}
+
+// checkpointReader counts the bytes read through it. The hash has taken in
+// everything returned by earlier reads when the next one starts, so that
+// is when a due checkpoint is saved.
+type checkpointReader struct {
+	r io.Reader
+	c *checkpointer
+}
+
+func (r *checkpointReader) Read(p []byte) (int, error) {
+	c := r.c
+	if !c.disabled && c.offset >= c.next {
+		c.save()
+		c.next = c.offset + checkpointInterval
+	}
+
+	// Reads stop at the next checkpoint, so that checkpoints are saved at
+	// whole multiples of checkpointInterval.
+	if !c.disabled {
+		p = p[:min(int64(len(p)), c.next-c.offset)]
+	}
+
+	n, err := r.r.Read(p)
+	c.offset += int64(n)
+
+	return n, err
+}
+
+func validateCheckpointDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	hasher, err := newHasher(hashOpts.algo)
+	if err == nil && !checkpointable(hasher) {
+		printWarning(fmt.Sprintf("--checkpoint-dir has no effect with --algo %s, whose hash state cannot be saved; use md5, sha1, sha256, sha512 or xxh64", hashOpts.algo))
+	}
+
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpointResumesInterruptedHash(t *testing.T) {
+	if testing.Short() {
+		t.Skip("hashes more than checkpointInterval bytes")
+	}
+	quietOutput(t)
+
+	// A sparse file just over one checkpoint long takes no disk space.
+	path := filepath.Join(t.TempDir(), "large.bin")
+	file, err := os.Create(path)
+	if err == nil {
+		err = file.Truncate(checkpointInterval + 1024*1024)
+	}
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := hashOptions{algo: defaultAlgorithm, checkpointDir: t.TempDir()}
+
+	want, err := hashFile(context.Background(), path, hashOptions{algo: defaultAlgorithm}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The hash is interrupted once it has read past the first checkpoint.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err = hashFile(ctx, path, opts, func(done int64, total int64) {
+		if done > checkpointInterval {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("interrupted hash returned %v, want context.Canceled", err)
+	}
+
+	// Changing a byte before the checkpoint, with the modification time
+	// put back, goes unnoticed by a resumed hash, which shows that it did
+	// not read that part again.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err = os.OpenFile(path, os.O_WRONLY, 0)
+	if err == nil {
+		_, err = file.WriteAt([]byte{1}, 0)
+		file.Close()
+	}
+	if err == nil {
+		err = os.Chtimes(path, info.ModTime(), info.ModTime())
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := hashFile(context.Background(), path, opts, nil)
+	if err != nil {
+		t.Fatalf("resumed hash failed: %v", err)
+	}
+
+	if got != want {
+		t.Errorf("resumed hash is %s, want %s as for the file before the change", got, want)
+	}
+
+	checkpoints, err := os.ReadDir(opts.checkpointDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoints) != 0 {
+		t.Errorf("checkpoint directory holds %d files after the hash finished, want none", len(checkpoints))
+	}
+}
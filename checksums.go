@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// checksumLine matches the lines of sha256sum, md5sum and the like
+	// ("<hash>  <path>", or "<hash> *<path>" for binary mode) and of BSD
+	// md5 -r ("<hash> <path>"). A leading backslash marks a path with
+	// escaped backslashes or newlines.
+	checksumLine = regexp.MustCompile(`^(\\?)([0-9a-fA-F]{8,}) [ *]?(.+)$`)
+	// taggedChecksumLine matches BSD-style lines as written by md5, shasum
+	// --tag and sha256sum --tag: "SHA256 (<path>) = <hash>".
+	taggedChecksumLine = regexp.MustCompile(`^(\\?)([A-Za-z0-9-]+) ?\((.+)\) ?= ([0-9a-fA-F]+)$`)
+)
+
+var checksumUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n")
+
+// parseManifest reads a manifest from r: a results CSV or a checksum list
+// in one of the formats above, told apart by the first line. source is
+// only used in error messages.
+func parseManifest(r io.Reader, source string) ([]HashedFileInfo, error) {
+	buffered := bufio.NewReader(r)
+
+	// Peek errors only mean a short file, which the parsers report.
+	start, _ := buffered.Peek(4096)
+	start = bytes.TrimPrefix(start, []byte(utf8BOM))
+
+	firstLine, _, _ := bytes.Cut(bytes.TrimLeft(start, "\r\n"), []byte("\n"))
+	firstLine = bytes.TrimSuffix(firstLine, []byte("\r"))
+
+	if !checksumLine.Match(firstLine) && !taggedChecksumLine.Match(firstLine) {
+		return parseResultsCsv(buffered, source)
+	}
+
+	return parseChecksumList(buffered, source)
+}
+
+// parseChecksumList reads the lines of a checksum list. Those tools record
+// no sizes, and untagged lines do not name the algorithm, so it is taken
+// from the length of the hash; see checksumAlgorithm.
+func parseChecksumList(r io.Reader, source string) ([]HashedFileInfo, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var files []HashedFileInfo
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if lineNo == 1 {
+			line = strings.TrimPrefix(line, utf8BOM)
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var escaped bool
+		var path, hash, algo string
+
+		if match := taggedChecksumLine.FindStringSubmatch(line); match != nil {
+			escaped, path, hash = match[1] != "", match[3], match[4]
+
+			algo = taggedAlgorithm(match[2])
+			if validateAlgorithm(algo) != nil {
+				return nil, fmt.Errorf("%s line %d uses %s, which is not one of: %s, optionally followed by %s", source, lineNo, match[2], strings.Join(algorithmNames(), ", "), treeSuffix)
+			}
+		} else if match := checksumLine.FindStringSubmatch(line); match != nil {
+			escaped, hash, path = match[1] != "", match[2], match[3]
+			algo = checksumAlgorithm(len(hash))
+		} else {
+			return nil, fmt.Errorf("failed to read %s: line %d is not a checksum line", source, lineNo)
+		}
+
+		if escaped {
+			path = checksumUnescaper.Replace(path)
+		}
+
+		files = append(files, HashedFileInfo{
+			Name:      filepath.Base(path),
+			Path:      path,
+			Hash:      strings.ToLower(hash),
+			Algorithm: algo,
+		})
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", source, err)
+	}
+
+	return files, nil
+}
+
+// taggedAlgorithm returns the algorithm named by the tag of a BSD-style
+// line, such as SHA256, SHA-256 or SHA256-TREE. Only the tree suffix keeps
+// its dash.
+func taggedAlgorithm(tag string) string {
+	tag = strings.ToLower(tag)
+
+	base, tree := strings.CutSuffix(tag, treeSuffix)
+	algo := strings.ReplaceAll(base, "-", "")
+	if tree {
+		algo += treeSuffix
+	}
+
+	return algo
+}
+
+// checksumAlgorithm guesses the algorithm of a hash with the given number
+// of hex digits. md5 and xxh128 hashes have the same length, and a tree
+// variant has the length of its plain algorithm, so the current --algo is
+// preferred when it fits. It returns "" for lengths no algorithm produces,
+// such as hashes written with --hash-length.
+func checksumAlgorithm(digits int) string {
+	var fits []string
+	for _, name := range algorithmNames() {
+		hasher, err := newHasher(name)
+		if err == nil && hasher.Size()*2 == digits {
+			fits = append(fits, name)
+		}
+	}
+
+	for _, name := range fits {
+		if name == strings.TrimSuffix(hashOpts.algo, treeSuffix) {
+			return hashOpts.algo
+		}
+	}
+
+	if len(fits) == 0 {
+		return ""
+	}
+
+	return fits[0]
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var detectCollisions bool
+
+func init() {
+	rootCmd.Flags().BoolVar(&detectCollisions, "detect-collisions", false, "Compare the files of every duplicate group byte for byte and report files that share a hash without being identical")
+}
+
+func validateDetectCollisions() error {
+	if detectCollisions && (len(hashOpts.normalizers) > 0 || hashOpts.decompress) {
+		return errors.New("--detect-collisions compares raw bytes, so it cannot be combined with --normalize, --normalize-eol, --trim-trailing-nulls or --decompress-compare")
+	}
+
+	return nil
+}
+
+// collisionStats counts the outcome of checking duplicate groups byte for
+// byte.
+type collisionStats struct {
+	confirmed  int
+	assumed    int
+	collisions int
+}
+
+// confirmGroups compares the files of each group with each other byte for
+// byte and splits a group wherever files turn out to differ despite sharing
+// a hash. Files that cannot be compared, such as files inside archives,
+// stay in their group as duplicates assumed from the hash. Groups left with
+// fewer than minGroupSize files are dropped.
+func confirmGroups(ctx context.Context, groups [][]HashedFileInfo, minGroupSize int) ([][]HashedFileInfo, collisionStats, error) {
+	var stats collisionStats
+	var confirmed [][]HashedFileInfo
+
+	for _, group := range groups {
+		var identical [][]HashedFileInfo
+
+		for _, file := range group {
+			placed := false
+
+			for i, same := range identical {
+				offset, err := firstDifference(ctx, same[0].Path, file.Path)
+				if ctx.Err() != nil {
+					return nil, stats, ctx.Err()
+				}
+				if err != nil {
+					printVerbose(fmt.Sprintf("Not compared: %s (%s), assumed to duplicate %s by its hash\n", file.Path, err, same[0].Path))
+					stats.assumed++
+					identical[i] = append(same, file)
+					placed = true
+					break
+				}
+
+				if offset < 0 {
+					stats.confirmed++
+					identical[i] = append(same, file)
+					placed = true
+					break
+				}
+
+				printWarning(fmt.Sprintf("hash collision: %s and %s share the hash %s but first differ at byte %d", same[0].Path, file.Path, file.Hash, offset))
+			}
+
+			if !placed {
+				if len(identical) > 0 {
+					stats.collisions++
+				}
+				identical = append(identical, []HashedFileInfo{file})
+			}
+		}
+
+		for _, same := range identical {
+			if len(same) >= minGroupSize {
+				confirmed = append(confirmed, same)
+			}
+		}
+	}
+
+	return confirmed, stats, nil
+}
+
+func printCollisionStats(stats collisionStats) {
+	printToStdOut(fmt.Sprintf("Byte-for-byte check: %d duplicates confirmed identical, %d assumed identical from their hash, %d hash collisions\n", stats.confirmed, stats.assumed, stats.collisions))
+}
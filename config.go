@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var printConfig string
+
+// envSetFlags holds the names of the flags whose value came from a DUPED_*
+// environment variable; see applyEnvDefaults.
+var envSetFlags = make(map[string]bool)
+
+func init() {
+	rootCmd.Flags().StringVar(&printConfig, "print-config", "", "Print the options the scan would use, with where each value came from, then exit (text or json)")
+	rootCmd.Flags().Lookup("print-config").NoOptDefVal = "text"
+}
+
+// configOption is one resolved option as printed by --print-config.
+type configOption struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+type resolvedConfig struct {
+	Directories []string       `json:"directories"`
+	Options     []configOption `json:"options"`
+}
+
+func validatePrintConfig(format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown format %q for --print-config (expected text or json)", format)
+	}
+
+	return nil
+}
+
+// optionSource tells whether a flag was given on the command line, set from
+// the environment or left at its default.
+func optionSource(flag *pflag.Flag) string {
+	switch {
+	case flag.Changed:
+		return "flag"
+	case envSetFlags[flag.Name]:
+		return envName(flag.Name)
+	}
+
+	return "default"
+}
+
+// printResolvedConfig prints every option of cmd with the value it holds
+// once the environment defaults and path expansion have been applied.
+func printResolvedConfig(cmd *cobra.Command, folderPaths []string, format string) error {
+	config := resolvedConfig{Directories: folderPaths}
+
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Name == "help" || flag.Name == "print-config" {
+			return
+		}
+
+		config.Options = append(config.Options, configOption{
+			Name:   flag.Name,
+			Value:  flag.Value.String(),
+			Source: optionSource(flag),
+		})
+	})
+
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(config)
+	}
+
+	for _, folderPath := range config.Directories {
+		fmt.Fprintf(os.Stdout, "directory=%s\n", folderPath)
+	}
+	for _, option := range config.Options {
+		fmt.Fprintf(os.Stdout, "%s=%s (%s)\n", option.Name, option.Value, option.Source)
+	}
+
+	return nil
+}
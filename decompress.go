@@ -0,0 +1,36 @@
+package main
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// decompressors maps the extensions of single-file compression formats
+// understood by --decompress-compare to a function that opens the
+// decompressed stream.
+var decompressors = map[string]func(r io.Reader) (io.ReadCloser, error){
+	".gz": func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	".bz2": func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	},
+	".zst": func(r io.Reader) (io.ReadCloser, error) {
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return decoder.IOReadCloser(), nil
+	},
+}
+
+func isCompressed(path string) bool {
+	_, ok := decompressors[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
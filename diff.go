@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <fileA> <fileB>",
+	Short: "Explain whether two files are duplicates",
+	Long: `diff hashes two files with the current --algo, --hash-* and normalization
+settings and reports whether a scan would consider them duplicates. It also
+compares their raw bytes and prints the offset of the first difference, which
+shows whether the files really differ or only hash differently because of
+--hash-include-name or --hash-include-mode, and whether a normalizer is what
+makes differing files match. No CSV is written.`,
+	Example: `  dupe-d diff a.jpg backup/a.jpg
+  dupe-d diff --normalize mp3 song.mp3 retagged.mp3`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		var sizes [2]int64
+		var hashes [2]string
+		paths := [2]string{args[0], args[1]}
+
+		for i := range paths {
+			path, err := expandHome(paths[i])
+			if err != nil {
+				return err
+			}
+			paths[i] = path
+
+			info, err := os.Stat(path)
+			if err != nil {
+				return fmt.Errorf("failed to get file stats: %w", err)
+			}
+			sizes[i] = info.Size()
+
+			hashes[i], err = hashFile(cmd.Context(), path, hashOpts, nil)
+			if err != nil {
+				return fmt.Errorf("failed to hash file %s: %w", path, err)
+			}
+
+			fmt.Fprintf(os.Stdout, "%s\n  Size: %d bytes (%s)\n  Hash: %s\n", path, sizes[i], formatBytes(sizes[i]), hashes[i])
+		}
+
+		offset, err := firstDifference(cmd.Context(), paths[0], paths[1])
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case offset < 0:
+			fmt.Fprintln(os.Stdout, "Contents: identical")
+		case offset == min(sizes[0], sizes[1]):
+			fmt.Fprintf(os.Stdout, "Contents: identical for the first %d bytes, then the shorter file ends\n", offset)
+		default:
+			fmt.Fprintf(os.Stdout, "Contents: first difference at byte offset %d\n", offset)
+		}
+
+		duplicates := hashes[0] == hashes[1] &&
+			(matchOn != matchHashSize || sizes[0] == sizes[1])
+
+		switch {
+		case duplicates && offset >= 0:
+			fmt.Fprintln(os.Stdout, "Result: duplicates, because the normalization or --decompress-compare settings hash the differing parts alike")
+		case duplicates:
+			fmt.Fprintln(os.Stdout, "Result: duplicates")
+		case hashes[0] == hashes[1]:
+			fmt.Fprintln(os.Stdout, "Result: not duplicates, the hashes match but --match-on hash+size also requires equal sizes")
+		case offset < 0:
+			fmt.Fprintln(os.Stdout, "Result: not duplicates, the contents are identical but --hash-include-name or --hash-include-mode tells them apart")
+		default:
+			fmt.Fprintln(os.Stdout, "Result: not duplicates")
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	diffCmd.Flags().StringVar(&matchOn, "match-on", matchHash, "What files must share to be duplicates: "+strings.Join(matchModes, ", "))
+
+	rootCmd.AddCommand(diffCmd)
+}
+
+// firstDifference streams both files and returns the offset of the first
+// byte at which they differ, or of the end of the shorter file. It returns
+// -1 if the files are identical.
+func firstDifference(ctx context.Context, pathA string, pathB string) (int64, error) {
+	fileA, err := os.Open(pathA)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer fileA.Close()
+
+	fileB, err := os.Open(pathB)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer fileB.Close()
+
+	readerA := &contextReader{ctx: ctx, r: fileA}
+	readerB := &contextReader{ctx: ctx, r: fileB}
+
+	bufA := make([]byte, 64*1024)
+	bufB := make([]byte, 64*1024)
+
+	var offset int64
+
+	for {
+		nA, errA := io.ReadFull(readerA, bufA)
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return 0, fmt.Errorf("failed to read %s: %w", pathA, errA)
+		}
+
+		nB, errB := io.ReadFull(readerB, bufB)
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return 0, fmt.Errorf("failed to read %s: %w", pathB, errB)
+		}
+
+		n := min(nA, nB)
+		if !bytes.Equal(bufA[:n], bufB[:n]) {
+			for i := 0; i < n; i++ {
+				if bufA[i] != bufB[i] {
+					return offset + int64(i), nil
+				}
+			}
+		}
+
+		if nA != nB {
+			return offset + int64(n), nil
+		}
+
+		if nA < len(bufA) {
+			return -1, nil
+		}
+
+		offset += int64(n)
+	}
+}
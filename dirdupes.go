@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type dirNode struct {
+	path    string
+	depth   int
+	entries []string
+	files   int
+	size    int64
+	hash    string
+}
+
+// findDuplicateDirs groups the directories under root whose content is
+// identical: the same multiset of file hashes, nested directories included.
+// File names are not compared. A group is left out when every member sits
+// inside a directory that is itself duplicated, since the parents already
+// cover it. Entries inside archives are ignored.
+func findDuplicateDirs(root string, files []HashedFileInfo, algo string) ([][]*dirNode, error) {
+	root = filepath.Clean(root)
+	nodes := make(map[string]*dirNode)
+
+	node := func(dir string) *dirNode {
+		n, ok := nodes[dir]
+		if !ok {
+			n = &dirNode{path: dir, depth: strings.Count(dir, string(filepath.Separator))}
+			nodes[dir] = n
+		}
+		return n
+	}
+
+	for _, file := range files {
+		// Symlinks recorded without a hash have no content to compare.
+		if file.Hash == "" || strings.Contains(file.Path, archivePathSeparator) {
+			continue
+		}
+
+		dir := filepath.Dir(filepath.Clean(file.Path))
+		node(dir).entries = append(node(dir).entries, "f:"+file.Hash)
+
+		for {
+			n := node(dir)
+			n.files++
+			n.size += file.Size
+
+			if dir == root || filepath.Dir(dir) == dir {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+	}
+
+	// Children have to be hashed before their parents.
+	ordered := make([]*dirNode, 0, len(nodes))
+	for _, n := range nodes {
+		ordered = append(ordered, n)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].depth != ordered[j].depth {
+			return ordered[i].depth > ordered[j].depth
+		}
+		return ordered[i].path < ordered[j].path
+	})
+
+	for _, n := range ordered {
+		hasher, err := newHasher(algo)
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Strings(n.entries)
+		for _, entry := range n.entries {
+			hasher.Write([]byte(entry))
+			hasher.Write([]byte{0})
+		}
+		n.hash = fmt.Sprintf("%x", hasher.Sum(nil))
+
+		if n.path != root {
+			parent := node(filepath.Dir(n.path))
+			parent.entries = append(parent.entries, "d:"+n.hash)
+		}
+	}
+
+	// Groups are reported shallowest first, members in path order.
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].depth != ordered[j].depth {
+			return ordered[i].depth < ordered[j].depth
+		}
+		return ordered[i].path < ordered[j].path
+	})
+
+	byHash := make(map[string][]*dirNode)
+	var order []string
+	for _, n := range ordered {
+		if _, ok := byHash[n.hash]; !ok {
+			order = append(order, n.hash)
+		}
+		byHash[n.hash] = append(byHash[n.hash], n)
+	}
+
+	duplicated := make(map[string]bool)
+	for _, group := range byHash {
+		if len(group) > 1 {
+			for _, n := range group {
+				duplicated[n.path] = true
+			}
+		}
+	}
+
+	var groups [][]*dirNode
+	for _, hash := range order {
+		group := byHash[hash]
+		if len(group) < 2 {
+			continue
+		}
+
+		covered := true
+		for _, n := range group {
+			if n.path == root || !duplicated[filepath.Dir(n.path)] {
+				covered = false
+				break
+			}
+		}
+
+		if !covered {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups, nil
+}
+
+func printDuplicateDirs(groups [][]*dirNode) {
+	var sb strings.Builder
+
+	sb.WriteString("\nDuplicate directories:\n")
+
+	if len(groups) == 0 {
+		sb.WriteString("  none found\n")
+	}
+
+	for _, group := range groups {
+		first := group[0]
+		reclaimable := first.size * int64(len(group)-1)
+
+		noun := "files"
+		if first.files == 1 {
+			noun = "file"
+		}
+
+		fmt.Fprintf(&sb, "  %d %s, %s each, %s reclaimable:\n", first.files, noun, formatBytes(first.size), formatBytes(reclaimable))
+		for _, n := range group {
+			fmt.Fprintf(&sb, "    %s\n", n.path)
+		}
+	}
+
+	printToStdOut(sb.String())
+}
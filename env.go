@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const envPrefix = "DUPED_"
+
+// mutuallyExclusiveAnnotation is the flag annotation cobra uses to record
+// the groups set up with MarkFlagsMutuallyExclusive.
+const mutuallyExclusiveAnnotation = "cobra_annotation_mutually_exclusive"
+
+// envIgnoredFlags are the flags of apply that change or remove files, or
+// guard against doing so. They are never read from the environment, so a
+// variable left exported cannot turn a dry look at a results file into a
+// deletion; they have to be given on the command line.
+var envIgnoredFlags = map[string]bool{
+	"delete":    true,
+	"hardlink":  true,
+	"reflink":   true,
+	"yes":       true,
+	"safe-mode": true,
+}
+
+// envName returns the environment variable that sets the default for a
+// flag, e.g. DUPED_MAX_OPEN_FILES for --max-open-files.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnvDefaults sets every flag of cmd that was not given on the command
+// line from its DUPED_* environment variable, so flags always take
+// precedence over the environment. The envIgnoredFlags are left alone.
+func applyEnvDefaults(cmd *cobra.Command) error {
+	var err error
+
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed || flag.Name == "help" || envIgnoredFlags[flag.Name] || conflictsWithChangedFlag(cmd, flag) {
+			return
+		}
+
+		value, ok := os.LookupEnv(envName(flag.Name))
+		if !ok {
+			return
+		}
+
+		// Setting the value directly rather than through the flag set
+		// leaves the flag unmarked as changed, so environment defaults
+		// never trip the mutually exclusive flag checks.
+		setErr := flag.Value.Set(value)
+		if setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %w", value, envName(flag.Name), setErr)
+			return
+		}
+
+		envSetFlags[flag.Name] = true
+	})
+
+	return err
+}
+
+// conflictsWithChangedFlag reports whether a flag that is mutually exclusive
+// with flag was given on the command line, e.g. --verbose when DUPED_QUIET
+// is set.
+func conflictsWithChangedFlag(cmd *cobra.Command, flag *pflag.Flag) bool {
+	for _, group := range flag.Annotations[mutuallyExclusiveAnnotation] {
+		for _, name := range strings.Fields(group) {
+			other := cmd.Flags().Lookup(name)
+			if other != nil && other.Changed {
+				return true
+			}
+		}
+	}
+
+	return false
+}
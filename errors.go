@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FileError is a failure to read or hash a single file.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e FileError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+func (e FileError) Unwrap() error {
+	return e.Err
+}
+
+// ScanErrors is returned by processFiles when some files could not be read
+// or hashed but the scan carried on with the rest. It is built once all
+// workers have finished, so it needs no locking.
+type ScanErrors struct {
+	errs []FileError
+}
+
+// Errors returns the failures, those found while walking the directory
+// tree before those found while hashing.
+func (e *ScanErrors) Errors() []FileError {
+	return e.errs
+}
+
+func (e *ScanErrors) Error() string {
+	var sb strings.Builder
+
+	noun := "files"
+	if len(e.errs) == 1 {
+		noun = "file"
+	}
+
+	fmt.Fprintf(&sb, "%d %s could not be scanned:", len(e.errs), noun)
+	for _, err := range e.errs {
+		fmt.Fprintf(&sb, "\n  %s", err)
+	}
+
+	return sb.String()
+}
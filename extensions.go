@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+var listExtensions string
+
+func init() {
+	rootCmd.Flags().StringVar(&listExtensions, "list-extensions", "", "Only walk the directory and list each file extension with its file count and total size, sorted by count (or by size with --list-extensions=size)")
+	rootCmd.Flags().Lookup("list-extensions").NoOptDefVal = "count"
+}
+
+type extensionUsage struct {
+	ext   string
+	files int
+	bytes int64
+}
+
+func validateListExtensions(sortBy string) error {
+	if sortBy != "count" && sortBy != "size" {
+		return fmt.Errorf("unknown sort order %q for --list-extensions (expected count or size)", sortBy)
+	}
+
+	return nil
+}
+
+// printExtensions walks folderPaths like a scan but hashes nothing, and
+// prints how many files of each extension it holds and their total size.
+// The --ext filter is ignored, since the listing is meant to help choose it.
+func printExtensions(ctx context.Context, folderPaths []string, opts scanOptions, sortBy string) error {
+	opts.exts = nil
+	opts.archives.enabled = false
+
+	files, failures, err := collectRoots(ctx, folderPaths, opts)
+	if err != nil {
+		return err
+	}
+
+	byExt := make(map[string]*extensionUsage)
+	for _, file := range files {
+		ext := summaryExtension(file.Path)
+
+		usage, ok := byExt[ext]
+		if !ok {
+			usage = &extensionUsage{ext: ext}
+			byExt[ext] = usage
+		}
+
+		usage.files++
+		usage.bytes += file.Size
+	}
+
+	usages := make([]extensionUsage, 0, len(byExt))
+	for _, usage := range byExt {
+		usages = append(usages, *usage)
+	}
+
+	sort.Slice(usages, func(i, j int) bool {
+		a, b := usages[i], usages[j]
+		if sortBy == "size" && a.bytes != b.bytes {
+			return a.bytes > b.bytes
+		}
+		if a.files != b.files {
+			return a.files > b.files
+		}
+		if a.bytes != b.bytes {
+			return a.bytes > b.bytes
+		}
+		return a.ext < b.ext
+	})
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "Extension\tFiles\tSize")
+	for _, usage := range usages {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", usage.ext, usage.files, formatBytes(usage.bytes))
+	}
+	tw.Flush()
+
+	if len(failures) > 0 {
+		return &ScanErrors{errs: failures}
+	}
+
+	return nil
+}
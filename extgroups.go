@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+var (
+	extGroups     []string
+	listExtGroups bool
+)
+
+func init() {
+	rootCmd.Flags().StringSliceVar(&extGroups, "ext-group", []string{}, "Also process the extensions of these presets: "+strings.Join(extGroupNames(), ", ")+" (see --list-ext-groups)")
+	rootCmd.Flags().BoolVar(&listExtGroups, "list-ext-groups", false, "List the --ext-group presets and their extensions, then exit")
+}
+
+// extensionGroups are the presets selectable with --ext-group.
+var extensionGroups = map[string][]string{
+	"images":    {"jpg", "jpeg", "png", "gif", "webp", "heic", "heif", "tif", "tiff", "bmp", "svg", "raw", "cr2", "nef", "arw", "dng"},
+	"videos":    {"mp4", "m4v", "mov", "avi", "mkv", "webm", "wmv", "flv", "mpg", "mpeg", "3gp", "mts"},
+	"audio":     {"mp3", "m4a", "aac", "flac", "wav", "ogg", "opus", "wma", "aiff", "alac"},
+	"documents": {"pdf", "doc", "docx", "xls", "xlsx", "ppt", "pptx", "odt", "ods", "odp", "rtf", "txt", "md", "epub"},
+	"archives":  {"zip", "tar", "gz", "tgz", "bz2", "xz", "zst", "7z", "rar", "iso"},
+}
+
+func extGroupNames() []string {
+	names := make([]string, 0, len(extensionGroups))
+	for name := range extensionGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// expandExtGroups returns the extensions of the named presets, each in
+// lower and upper case since --ext matches extensions exactly and cameras
+// commonly write names like IMG_0001.JPG.
+func expandExtGroups(names []string) ([]string, error) {
+	var exts []string
+
+	for _, name := range names {
+		group, ok := extensionGroups[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown extension group %q (expected one of: %s)", name, strings.Join(extGroupNames(), ", "))
+		}
+
+		for _, ext := range group {
+			exts = append(exts, ext, strings.ToUpper(ext))
+		}
+	}
+
+	return exts, nil
+}
+
+// selectedExtensions returns the extensions given with --ext followed by
+// those of the --ext-group presets, in the form used for matching.
+func selectedExtensions() ([]string, error) {
+	grouped, err := expandExtGroups(extGroups)
+	if err != nil {
+		return nil, err
+	}
+
+	var exts []string
+	for _, ext := range formatExtensions(append(append([]string{}, extensions...), grouped...)) {
+		if !slices.Contains(exts, ext) {
+			exts = append(exts, ext)
+		}
+	}
+
+	return exts, nil
+}
+
+func printExtGroups() {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "Group\tExtensions")
+	for _, name := range extGroupNames() {
+		fmt.Fprintf(tw, "%s\t%s\n", name, strings.Join(extensionGroups[name], ", "))
+	}
+	tw.Flush()
+}
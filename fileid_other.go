@@ -0,0 +1,23 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// fileID is not supported on this platform; links to the same file are
+// reported like any other duplicate.
+func fileID(path string, info os.FileInfo) string {
+	return ""
+}
+
+// deviceID is not supported on this platform, so --one-file-system has no
+// effect.
+func deviceID(path string, info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+// allocatedSize is not supported on this platform, so sparse files count
+// with their full size.
+func allocatedSize(path string, info os.FileInfo) (int64, bool) {
+	return 0, false
+}
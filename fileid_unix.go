@@ -0,0 +1,43 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileID returns an identifier for the underlying file of info, formed from
+// its device and inode numbers, or "" if it cannot be determined.
+func fileID(path string, info os.FileInfo) string {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf("%d:%d", stat.Dev, stat.Ino)
+}
+
+// deviceID returns the number of the device holding the file of info.
+func deviceID(path string, info os.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	return uint64(stat.Dev), true
+}
+
+// allocatedSize returns the number of bytes the file of info occupies on
+// disk, which is less than its size for sparse files.
+func allocatedSize(path string, info os.FileInfo) (int64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	// Blocks is always counted in 512-byte units, whatever the block size
+	// of the file system.
+	return int64(stat.Blocks) * 512, true
+}
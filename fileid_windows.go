@@ -0,0 +1,76 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// invalidFileSize is returned by GetCompressedFileSizeW on failure, but is
+// also a valid low half of a size, so the error has to be checked as well.
+const invalidFileSize = 0xFFFFFFFF
+
+var procGetCompressedFileSizeW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetCompressedFileSizeW")
+
+// fileInformation opens path without preventing others from using it and
+// returns its file information.
+func fileInformation(path string) (syscall.ByHandleFileInformation, error) {
+	var data syscall.ByHandleFileInformation
+
+	pathp, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return data, err
+	}
+
+	handle, err := syscall.CreateFile(pathp, 0, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return data, err
+	}
+	defer syscall.CloseHandle(handle)
+
+	err = syscall.GetFileInformationByHandle(handle, &data)
+	return data, err
+}
+
+// fileID returns an identifier for the underlying file at path, formed from
+// its volume serial number and file index, or "" if it cannot be determined.
+func fileID(path string, info os.FileInfo) string {
+	data, err := fileInformation(path)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%d:%d", data.VolumeSerialNumber, uint64(data.FileIndexHigh)<<32|uint64(data.FileIndexLow))
+}
+
+// deviceID returns the serial number of the volume holding the file at
+// path.
+func deviceID(path string, info os.FileInfo) (uint64, bool) {
+	data, err := fileInformation(path)
+	if err != nil {
+		return 0, false
+	}
+
+	return uint64(data.VolumeSerialNumber), true
+}
+
+// allocatedSize returns the number of bytes the file at path occupies on
+// disk, which is less than its size for sparse and NTFS-compressed files.
+func allocatedSize(path string, info os.FileInfo) (int64, bool) {
+	pathp, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, false
+	}
+
+	var high uint32
+	low, _, err := procGetCompressedFileSizeW.Call(uintptr(unsafe.Pointer(pathp)), uintptr(unsafe.Pointer(&high)))
+	if uint32(low) == invalidFileSize && err != syscall.Errno(0) {
+		return 0, false
+	}
+
+	return int64(high)<<32 | int64(uint32(low)), true
+}
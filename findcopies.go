@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var findCopiesCmd = &cobra.Command{
+	Use:   "find-copies <reference-file> <directory>",
+	Short: "Find every copy of one file within a directory",
+	Long: `find-copies hashes the reference file once, then walks the directory and
+prints the path of every file with the same content. Only files of the same
+size as the reference are hashed, so this is much quicker than a full scan.
+With --normalize, --normalize-eol, --trim-trailing-nulls or
+--decompress-compare, files of any size can match, so all of them are hashed.
+The reference file itself is not listed. No CSV is written.`,
+	Example: `  dupe-d find-copies photo.jpg ~/Pictures
+  dupe-d find-copies --workers 4 installer.iso /mnt/backup`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		refPath, err := expandHome(args[0])
+		if err != nil {
+			return err
+		}
+
+		refInfo, err := os.Stat(refPath)
+		if err != nil {
+			return fmt.Errorf("failed to get file stats: %w", err)
+		}
+		if !refInfo.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", refPath)
+		}
+
+		folderPath, err := validateDirectory(args[1])
+		if errors.Is(err, errFileNotDirectory) {
+			return fmt.Errorf("%w; use \"dupe-d diff %s %s\" to compare two files", err, args[0], args[1])
+		}
+		if err != nil {
+			return err
+		}
+
+		if workers < 1 {
+			return fmt.Errorf("workers must be at least 1: %d", workers)
+		}
+
+		opts := scanOptions{
+			hash:        hashOpts,
+			workers:     workers,
+			skipVCS:     skipVCS,
+			symlinkMode: symlinkSkip,
+			emptyFiles:  emptyUnique,
+		}
+
+		if skipPattern != "" {
+			opts.skipPattern, err = regexp.Compile(skipPattern)
+			if err != nil {
+				return fmt.Errorf("invalid --skip-files-matching pattern: %w", err)
+			}
+		}
+
+		refHash, err := hashFile(cmd.Context(), refPath, hashOpts, nil)
+		if err != nil {
+			return fmt.Errorf("failed to hash file %s: %w", refPath, err)
+		}
+
+		files, failures, err := collectRoots(cmd.Context(), []string{folderPath}, opts)
+		if err != nil {
+			return err
+		}
+
+		// Normalizers and --decompress-compare hash something other than
+		// the raw bytes, so differently sized files can still match.
+		anySize := len(hashOpts.normalizers) > 0 || hashOpts.decompress
+
+		refAbs, err := filepath.Abs(refPath)
+		if err != nil {
+			return fmt.Errorf("failed to make %s absolute: %w", refPath, err)
+		}
+
+		var candidates []HashedFileInfo
+		for _, file := range files {
+			if !anySize && file.Size != refInfo.Size() {
+				continue
+			}
+
+			fileAbs, err := filepath.Abs(file.Path)
+			if err == nil && fileAbs == refAbs {
+				continue
+			}
+
+			candidates = append(candidates, file)
+		}
+
+		printToStdOut(fmt.Sprintf("Hashing %d of %d files that could be copies of %s\n", len(candidates), len(files), refPath))
+
+		matches := make([]bool, len(candidates))
+		candidateErrs := make([]error, len(candidates))
+
+		err = runWorkers(cmd.Context(), len(candidates), opts.workers, func(i int) error {
+			hash, err := hashFile(cmd.Context(), candidates[i].Path, hashOpts, nil)
+			if cmd.Context().Err() != nil {
+				return cmd.Context().Err()
+			}
+
+			candidateErrs[i] = err
+			matches[i] = err == nil && hash == refHash
+			return nil
+		})
+		if err != nil {
+			return scanStoppedError(err)
+		}
+
+		var copies int
+		for i, candidate := range candidates {
+			if candidateErrs[i] != nil {
+				failures = append(failures, FileError{Path: candidate.Path, Err: candidateErrs[i]})
+			}
+
+			if matches[i] {
+				fmt.Fprintln(os.Stdout, candidate.Path)
+				copies++
+			}
+		}
+
+		printToStdOut(fmt.Sprintf("Found %d copies of %s\n", copies, refPath))
+
+		if len(failures) > 0 {
+			return &ScanErrors{errs: failures}
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	findCopiesCmd.Flags().IntVar(&workers, "workers", 1, "Number of files to hash concurrently")
+	findCopiesCmd.Flags().StringVar(&skipPattern, "skip-files-matching", "", "Skip files whose full path matches this regular expression")
+	findCopiesCmd.Flags().BoolVar(&skipVCS, "skip-vcs", false, "Skip version-control metadata directories ("+strings.Join(vcsDirs, ", ")+")")
+
+	rootCmd.AddCommand(findCopiesCmd)
+}
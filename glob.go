@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var globPatterns []string
+
+func init() {
+	rootCmd.Flags().StringArrayVar(&globPatterns, "glob", []string{}, "Scan the files matching this pattern, where ** matches any number of directories (can be repeated)")
+}
+
+// globPattern is a --glob pattern split at the last directory that holds no
+// wildcard: root is walked and the files in it are matched against the
+// remaining segments.
+type globPattern struct {
+	text     string
+	root     string
+	segments []string
+}
+
+// parseGlob splits pattern into the directory to walk and the segments to
+// match below it. Every segment but the last can be **, which matches any
+// number of directories, including none.
+func parseGlob(pattern string) (globPattern, error) {
+	expanded, err := expandHome(pattern)
+	if err != nil {
+		return globPattern{}, err
+	}
+
+	segments := strings.Split(filepath.ToSlash(expanded), "/")
+
+	for _, segment := range segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return globPattern{}, fmt.Errorf("invalid --glob pattern %q: %w", pattern, err)
+		}
+	}
+
+	// The last segment always names files, even without a wildcard.
+	fixed := 0
+	for fixed < len(segments)-1 && !strings.ContainsAny(segments[fixed], `*?[\`) {
+		fixed++
+	}
+
+	root := strings.Join(segments[:fixed], "/")
+	switch {
+	case root == "" && fixed > 0:
+		root = "/"
+	case root == "":
+		root = "."
+	}
+
+	if segments[len(segments)-1] == "" || segments[len(segments)-1] == "**" {
+		return globPattern{}, fmt.Errorf("invalid --glob pattern %q: it must end in a file name pattern such as *.jpg", pattern)
+	}
+
+	return globPattern{text: pattern, root: filepath.FromSlash(root), segments: segments[fixed:]}, nil
+}
+
+// matchesGlob reports whether rel, a slash-separated path relative to the
+// root of one of patterns, matches that pattern. With prefix set, rel is a
+// directory and the question is whether files below it could match, so the
+// walk can skip the directories that cannot.
+func matchesGlob(rel string, patterns []globPattern, prefix bool) bool {
+	parts := strings.Split(rel, "/")
+	if rel == "." {
+		parts = nil
+	}
+
+	for _, pattern := range patterns {
+		if matchSegments(pattern.segments, parts, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchSegments(pattern []string, parts []string, prefix bool) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// ** takes none of the remaining parts, or one more.
+			if matchSegments(pattern[1:], parts, prefix) {
+				return true
+			}
+
+			return len(parts) > 0 && matchSegments(pattern, parts[1:], prefix)
+		}
+
+		if len(parts) == 0 {
+			return prefix
+		}
+
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+
+		pattern, parts = pattern[1:], parts[1:]
+	}
+
+	return len(parts) == 0 && !prefix
+}
+
+// addGlobRoots parses patterns and adds the directories they are split at
+// to folderPaths, made absolute with --absolute. It returns the patterns
+// by directory, leaving out directories that are also scanned whole.
+func addGlobRoots(patterns []string, folderPaths []string) (map[string][]globPattern, []string, error) {
+	if len(patterns) == 0 {
+		return nil, folderPaths, nil
+	}
+
+	whole := slices.Clone(folderPaths)
+	globs := make(map[string][]globPattern)
+
+	for _, text := range patterns {
+		pattern, err := parseGlob(text)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		root, err := validateDirectory(pattern.root)
+		if err != nil {
+			return nil, nil, fmt.Errorf("--glob %s: %w", text, err)
+		}
+
+		root = filepath.Clean(root)
+		if absolutePaths {
+			root, err = filepath.Abs(root)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to make %s absolute: %w", root, err)
+			}
+		}
+
+		if slices.Contains(whole, root) {
+			continue
+		}
+
+		if _, ok := globs[root]; !ok {
+			folderPaths = append(folderPaths, root)
+		}
+		globs[root] = append(globs[root], pattern)
+	}
+
+	return globs, folderPaths, nil
+}
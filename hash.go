@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var hashCmd = &cobra.Command{
+	Use:   "hash <file>...",
+	Short: "Print the hash of one or more files",
+	Long: `hash prints the hash of each given file using the algorithm selected with --algo.
+With a single file only the hash is printed; with several, each line has the
+form "<hash>  <path>" like sha256sum. A path of "-" reads from standard input.
+No CSV is written.`,
+	Example: `  dupe-d hash image.jpg
+  dupe-d hash --algo md5 *.iso
+  cat image.jpg | dupe-d hash -`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		var failed int
+
+		for _, path := range args {
+			hash, err := hashPath(cmd.Context(), path)
+			if cmd.Context().Err() != nil {
+				return cmd.Context().Err()
+			}
+			if err != nil {
+				printToStdErr(fmt.Errorf("failed to hash file %s: %w", path, err))
+				failed++
+				continue
+			}
+
+			if len(args) == 1 {
+				fmt.Fprintln(os.Stdout, hash)
+			} else {
+				fmt.Fprintf(os.Stdout, "%s  %s\n", hash, path)
+			}
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("failed to hash %d of %d files", failed, len(args))
+		}
+
+		return nil
+	},
+}
+
+// hashPath hashes the file at path, or standard input when path is "-".
+// Normalizers and --decompress-compare go by file extension, so they do
+// not apply to standard input.
+func hashPath(ctx context.Context, path string) (string, error) {
+	if path == "-" {
+		return hashReader(ctx, os.Stdin, path, 0, hashOpts)
+	}
+
+	path, err := expandHome(path)
+	if err != nil {
+		return "", err
+	}
+
+	return hashFile(ctx, path, hashOpts, nil)
+}
+
+func init() {
+	rootCmd.AddCommand(hashCmd)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+var (
+	postHook       string
+	postHookStrict bool
+)
+
+func init() {
+	rootCmd.Flags().StringVar(&postHook, "post-hook", "", "Run this shell command once the scan ends, also after a partial or interrupted scan, with the results in DUPED_SCAN_* environment variables")
+	rootCmd.Flags().BoolVar(&postHookStrict, "post-hook-strict", false, "Fail the run when the --post-hook command exits with a non-zero status")
+}
+
+// scanStatus describes how a scan ended for the post-scan hook.
+func scanStatus(err error) string {
+	var scanErrs *ScanErrors
+
+	switch {
+	case err == nil:
+		return "complete"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timed-out"
+	case errors.Is(err, errReadsCapped):
+		return "capped"
+	case errors.Is(err, context.Canceled):
+		return "interrupted"
+	case errors.As(err, &scanErrs):
+		return "partial"
+	}
+
+	return "failed"
+}
+
+// hookEnv returns the environment of the post-scan hook: that of dupe-d plus
+// the outcome of the scan. The variables are prefixed with DUPED_SCAN_ so
+// they cannot be mistaken for flag defaults by a dupe-d run from the hook.
+func hookEnv(runErr error, files []HashedFileInfo, groups [][]HashedFileInfo, outputs []string) []string {
+	var totalBytes int64
+	for _, file := range files {
+		totalBytes += file.Size
+	}
+
+	var redundant int
+	for _, group := range groups {
+		redundant += len(group) - 1
+	}
+
+	var output string
+	if len(outputs) > 0 {
+		output = outputs[0]
+	}
+
+	return append(os.Environ(),
+		"DUPED_SCAN_STATUS="+scanStatus(runErr),
+		"DUPED_SCAN_OUTPUT="+output,
+		"DUPED_SCAN_OUTPUTS="+strings.Join(outputs, string(os.PathListSeparator)),
+		"DUPED_SCAN_FILES="+strconv.Itoa(len(files)),
+		"DUPED_SCAN_BYTES="+strconv.FormatInt(totalBytes, 10),
+		"DUPED_SCAN_GROUPS="+strconv.Itoa(len(groups)),
+		"DUPED_SCAN_REDUNDANT="+strconv.Itoa(redundant),
+		"DUPED_SCAN_RECLAIMABLE_BYTES="+strconv.FormatInt(reclaimableBytes(groups), 10),
+	)
+}
+
+// runPostHook runs the --post-hook command and reports its exit status. It
+// returns runErr, the outcome of the run so far, unless the run succeeded
+// and --post-hook-strict makes a failing hook fail it too.
+func runPostHook(command string, runErr error, files []HashedFileInfo, groups [][]HashedFileInfo, outputs []string) error {
+	// The hook is not tied to the scan's context, so it still runs after
+	// Ctrl-C or --timeout stopped the scan.
+	cmd := hookCommand(command)
+	cmd.Env = hookEnv(runErr, files, groups, outputs)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	hookErr := cmd.Run()
+
+	var exitErr *exec.ExitError
+	switch {
+	case hookErr == nil:
+		printToStdOut("Post-scan hook exited with status 0\n")
+		return runErr
+	case errors.As(hookErr, &exitErr):
+		printWarning(fmt.Sprintf("post-scan hook exited with status %d", exitErr.ExitCode()))
+	default:
+		printWarning(fmt.Sprintf("failed to run post-scan hook: %s", hookErr))
+	}
+
+	if runErr == nil && postHookStrict {
+		return fmt.Errorf("post-scan hook failed: %w", hookErr)
+	}
+
+	return runErr
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "os/exec"
+
+// hookCommand runs command through the POSIX shell.
+func hookCommand(command string) *exec.Cmd {
+	return exec.Command("/bin/sh", "-c", command)
+}
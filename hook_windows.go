@@ -0,0 +1,17 @@
+//go:build windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// hookCommand runs command through cmd.exe. The command line is passed on
+// verbatim, since cmd.exe does not follow the quoting rules exec applies.
+func hookCommand(command string) *exec.Cmd {
+	cmd := exec.Command("cmd.exe")
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: "cmd.exe /C " + command}
+
+	return cmd
+}
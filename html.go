@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// htmlReport is a self-contained page listing the duplicate groups, each
+// collapsible, with every path linked through a file:// URL.
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>dupe-d report</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; }
+table.summary td { padding: 0.1em 1.5em 0.1em 0; }
+table.summary td:last-child { text-align: right; }
+details { border: 1px solid #ddd; border-radius: 4px; margin: 0.4em 0; padding: 0.3em 0.6em; }
+summary { cursor: pointer; }
+.hash { font-family: ui-monospace, monospace; }
+.details { color: #666; }
+ul { margin: 0.4em 0; }
+li { font-family: ui-monospace, monospace; margin: 0.1em 0; }
+</style>
+</head>
+<body>
+<h1>dupe-d report</h1>
+<p class="details">Generated {{.GeneratedAt.Format "2006-01-02 15:04:05 MST"}} with {{.Algorithm}}</p>
+<table class="summary">
+<tr><td>Files listed</td><td>{{.Files}} ({{.TotalSize}})</td></tr>
+<tr><td>Duplicate groups</td><td>{{len .Groups}}</td></tr>
+<tr><td>Redundant files</td><td>{{.Redundant}}</td></tr>
+<tr><td>Reclaimable space</td><td>{{.Reclaimable}}</td></tr>
+</table>
+<h2>Duplicate groups</h2>
+{{range .Groups}}<details>
+<summary>Group {{.ID}}: <span class="hash">{{.Hash}}</span> <span class="details">({{len .Files}} files, {{.Size}} each, {{.Reclaimable}} reclaimable)</span></summary>
+<ul>
+{{range .Files}}<li><a href="{{.URL}}">{{.Path}}</a>{{if .Link}} <span class="details">({{.Link}})</span>{{end}}</li>
+{{end}}</ul>
+</details>
+{{else}}<p>No duplicate groups found.</p>
+{{end}}{{if .Others}}<h2>Other files</h2>
+<details>
+<summary>{{len .Others}} files in no duplicate group</summary>
+<ul>
+{{range .Others}}<li><a href="{{.URL}}">{{.Path}}</a>{{if .Link}} <span class="details">({{.Link}})</span>{{end}}</li>
+{{end}}</ul>
+</details>
+{{end}}</body>
+</html>
+`))
+
+type htmlReportData struct {
+	GeneratedAt time.Time
+	Algorithm   string
+	Files       int
+	TotalSize   string
+	Redundant   int
+	Reclaimable string
+	Groups      []htmlGroup
+	Others      []htmlFile
+}
+
+type htmlGroup struct {
+	ID          int
+	Hash        string
+	Size        string
+	Reclaimable string
+	Files       []htmlFile
+}
+
+type htmlFile struct {
+	Path string
+	// URL is typed so the template keeps the file: scheme, which it would
+	// otherwise replace as unsafe.
+	URL  template.URL
+	Link string
+}
+
+// fileURL returns the file:// URL of path, made absolute first so the link
+// works wherever the report is opened from.
+func fileURL(path string) string {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		absPath = path
+	}
+
+	slashed := filepath.ToSlash(absPath)
+	// Windows paths such as C:/dir need a leading slash to be a URL path.
+	if !strings.HasPrefix(slashed, "/") {
+		slashed = "/" + slashed
+	}
+
+	return (&url.URL{Scheme: "file", Path: slashed}).String()
+}
+
+// newHtmlReport builds the report from the files being written. Groups are
+// rebuilt from the group IDs, so the report shows the same groups as the
+// group-id column; files of groups left out by --max-results or filters
+// are listed with the other files.
+func newHtmlReport(files []HashedFileInfo) htmlReportData {
+	report := htmlReportData{
+		GeneratedAt: outputTime(),
+		Algorithm:   hashOpts.algo,
+		Files:       len(files),
+	}
+
+	var totalBytes int64
+	var groups [][]HashedFileInfo
+	for _, file := range files {
+		totalBytes += file.Size
+
+		entry := htmlFile{Path: file.Path, URL: template.URL(fileURL(file.Path)), Link: linkDescription(file)}
+		if file.GroupID == 0 {
+			report.Others = append(report.Others, entry)
+			continue
+		}
+
+		for len(groups) < file.GroupID {
+			groups = append(groups, nil)
+			report.Groups = append(report.Groups, htmlGroup{ID: len(groups)})
+		}
+
+		groups[file.GroupID-1] = append(groups[file.GroupID-1], file)
+		report.Groups[file.GroupID-1].Files = append(report.Groups[file.GroupID-1].Files, entry)
+	}
+
+	// Every ID below the highest is used, but a filter such as
+	// --keepers-only may have kept a single file of a group, or none.
+	var listed []htmlGroup
+	var listedGroups [][]HashedFileInfo
+	for i, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+
+		entry := report.Groups[i]
+		entry.Hash = group[0].Hash
+		entry.Size = formatBytes(group[0].Size)
+		entry.Reclaimable = formatBytes(reclaimableBytes([][]HashedFileInfo{group}))
+
+		listed = append(listed, entry)
+		listedGroups = append(listedGroups, group)
+		report.Redundant += len(group) - 1
+	}
+
+	report.Groups = listed
+	report.TotalSize = formatBytes(totalBytes)
+	report.Reclaimable = formatBytes(reclaimableBytes(listedGroups))
+
+	return report
+}
+
+func writeToHtml(hashedFilesInfo []HashedFileInfo, outputFilename string) error {
+	file, err := createOutput(outputFilename)
+	if err != nil {
+		return fmt.Errorf("failed to create HTML file: %w", err)
+	}
+	defer file.Close()
+
+	buffered := bufio.NewWriter(file)
+
+	err = htmlReport.Execute(buffered, newHtmlReport(hashedFilesInfo))
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write content to HTML: %w", err)
+	}
+
+	// Closing finishes a compressed stream, so its error matters.
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write HTML file: %w", err)
+	}
+
+	absPath, err := filepath.Abs(outputFilename)
+	if err != nil {
+		absPath = outputFilename
+	}
+
+	printToStdOut(fmt.Sprintf("Output written to: %s\n", absPath))
+
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// lockFile is not supported on this platform. Cache files are still
+// replaced atomically, but entries from two scans saving at the same moment
+// may not both be kept.
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
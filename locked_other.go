@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package main
+
+// isLockedError always reports false where lock errors cannot be told apart
+// from other failures.
+func isLockedError(err error) bool {
+	return false
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isLockedError reports whether err means the file is busy or locked by
+// another process, as opposed to unreadable.
+func isLockedError(err error) bool {
+	return errors.Is(err, syscall.EWOULDBLOCK) || errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.ETXTBSY)
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Win32 error codes returned when another process holds the file open
+// without sharing, or has locked the range being read.
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// isLockedError reports whether err means the file is busy or locked by
+// another process, as opposed to unreadable.
+func isLockedError(err error) bool {
+	return errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation)
+}
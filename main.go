@@ -1,239 +1,2434 @@
 package main
 
 import (
-	"crypto/sha256"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/csv"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
+	"maps"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	extensions []string
+	extensions     []string
+	sizeTolerance  float64
+	quiet          bool
+	verbose        bool
+	hashOpts       hashOptions
+	countOnly      string
+	minGroupSize   int
+	outputFiles    []string
+	outputDir      string
+	archiveOpts    archiveOptions
+	skipPattern    string
+	resumeFile     string
+	normalize      []string
+	workers        int
+	maxOpenFiles   int
+	preview        int
+	scanTimeout    time.Duration
+	groupSep       bool
+	skipVCS        bool
+	keepersOnly    bool
+	uniquesOnly    bool
+	duplicatesOnly bool
+	stdoutFormat   string
+	noColor        bool
+	includeSpecial bool
+	legacyJson     bool
+	strict         bool
+	skipLocked     bool
+	normalizeEOL   []string
+	trimNulls      []string
+	cacheFilePath  string
+	sinceLastRun   bool
+	minSavings     byteSize
+	maxOutputSize  byteSize
+	maxTotalReads  byteSize
+	progressFile   string
+	stayWithinRoot bool
+	absolutePaths  bool
+	probe          bool
+	probeFraction  float64
+	dirDupes       bool
+	symlinkMode    string
+	maxResults     int
+	noProgress     bool
+	matchOn        string
+	csvBOM         bool
+	oneFileSystem  bool
+	zeroAsUnique   bool
+	groupZeroSize  bool
+	deterministic  bool
+	utcTimestamps  bool
+	noOutput       bool
+	dirsFile       string
+	gzipOutput     bool
+	fixedTime      time.Time
+	crossDevice    []string
+	allowEmpty     bool
 )
 
+// Exit codes for scans that did not run to completion, so scripts can tell
+// them apart from other failures (exit code 1).
+const (
+	exitTimedOut    = 3
+	exitCapped      = 4
+	exitInterrupted = 130
+)
+
+// errFileNotDirectory is returned by validateDirectory for a regular file,
+// so callers can point to the commands that take files.
+var errFileNotDirectory = errors.New("not a directory")
+
+// errReadsCapped stops a scan that has read --max-total-reads bytes.
+var errReadsCapped = errors.New("--max-total-reads reached")
+
+// scanOptions controls which files a scan picks up and how they are hashed.
+type scanOptions struct {
+	exts        []string
+	skipPattern *regexp.Regexp
+	hash        hashOptions
+	archives    archiveOptions
+	// resume holds previously hashed files by path. A file whose size and
+	// modification time are unchanged keeps its recorded hash.
+	resume map[string]HashedFileInfo
+	// workers is the number of files hashed concurrently.
+	workers int
+	// globs holds the --glob patterns of each directory that is only
+	// scanned for them; see parseGlob. Other directories are scanned whole.
+	globs map[string][]globPattern
+	// order is one of orderModes and decides which files are hashed
+	// first. Results are returned in walk order either way.
+	order string
+	// autoWorkers tunes the number of workers while hashing instead; see
+	// runAutoWorkers.
+	autoWorkers bool
+	// skipVCS leaves out version-control metadata directories.
+	skipVCS bool
+	// includeSpecial hashes FIFOs, sockets and device files rather than
+	// skipping them.
+	includeSpecial bool
+	// strict stops the scan at the first file that cannot be read or
+	// hashed instead of collecting the failures in a ScanErrors.
+	strict bool
+	// skipLocked leaves out files that are locked or busy in another
+	// process instead of treating them as failures.
+	skipLocked bool
+	// cache, when set, supplies hashes from earlier scans and collects the
+	// ones computed in this scan.
+	cache *hashCache
+	// devices, when set, limits the walk to directories on these devices;
+	// see resolveDevices.
+	devices map[uint64]bool
+	// emptyHash, when set, is the digest of empty content under hash. It
+	// is given to zero-byte files without opening them.
+	emptyHash string
+	// emptyFiles is one of the empty* modes and decides whether zero-byte
+	// files are left out, listed on their own or grouped.
+	emptyFiles string
+	// symlinkMode is one of symlinkModes and decides what happens to
+	// symbolic links met during the walk.
+	symlinkMode string
+	// stayWithinRoot keeps --symlink-mode follow from following symlinks
+	// whose target lies outside every scanned directory.
+	stayWithinRoot bool
+	// roots are the scanned directories with their symlinks resolved, set
+	// by collectRoots for stayWithinRoot.
+	roots []string
+	// maxReads, when positive, stops hashing once this many bytes have
+	// been read from files, like a timeout.
+	maxReads int64
+	// progressEvents, when set, receives machine-readable progress events;
+	// see progressTracker.writeEventsTo.
+	progressEvents io.Writer
+}
+
+const (
+	symlinkSkip   = "skip"
+	symlinkRecord = "record"
+	symlinkFollow = "follow"
+)
+
+var symlinkModes = []string{symlinkSkip, symlinkRecord, symlinkFollow}
+
+// Zero-byte files all share the hash of empty content, which says nothing
+// about whether they are copies of each other, so by default they are left
+// out of the scan.
+const (
+	emptySkip   = "skip"
+	emptyUnique = "unique"
+	emptyGroup  = "group"
+)
+
+// vcsDirs are the version-control metadata directories left out by
+// --skip-vcs.
+var vcsDirs = []string{".git", ".svn", ".hg"}
+
+// hashOptions controls how a file's digest is computed and what goes into
+// it besides its content.
+type hashOptions struct {
+	algo        string
+	includeName bool
+	includeMode bool
+	// length truncates the hex digest to this many characters; 0 keeps the
+	// full digest.
+	length int
+	// normalizers maps lower-case file extensions to the normalizer used
+	// for files with that extension.
+	normalizers map[string]contentNormalizer
+	// decompress hashes the decompressed stream of files in a known
+	// single-file compression format instead of their raw bytes.
+	decompress bool
+	// chunkWorkers is how many chunks of a file a tree algorithm hashes at
+	// a time. It does not change the digest.
+	chunkWorkers int
+	// checkpointDir, when set, is where the hash state of large files is
+	// saved as they are read, so an interrupted hash can be resumed. It
+	// does not change the digest.
+	checkpointDir string
+}
+
 type HashedFileInfo struct {
-	Name string
-	Path string
-	Size int64
-	Hash string
+	Name    string
+	Path    string
+	Size    int64
+	Hash    string
+	ModTime time.Time
+	// RawHash is the hash of the file's raw bytes when Hash was computed
+	// over its decompressed content with --decompress-compare.
+	RawHash string
+	// FileID identifies the underlying file (device and inode) so that
+	// symlinks and hard links to the same file can be told apart from
+	// real copies. Empty when unknown.
+	FileID string
+	// Symlink is set when Path is a symbolic link, or lies in a directory
+	// reached through one.
+	Symlink bool
+	// LinkedTo is the path of another scanned entry that refers to the
+	// same underlying file, if any.
+	LinkedTo string
+	// Algorithm is the hash algorithm recorded for a file read from a
+	// results file. Files hashed by this run leave it empty and use the
+	// --algo in effect.
+	Algorithm string
+	// Mode is the file's type and permission bits, for files read from
+	// the file system.
+	Mode fs.FileMode
+	// Sparse is set when the file occupies less space on disk than its
+	// size, as sparse files do, and Allocated is then the space it does
+	// occupy.
+	Sparse    bool
+	Allocated int64
+	// GroupID numbers the duplicate group the file belongs to, from 1 in
+	// the order the groups are reported, or is 0 for files in none.
+	GroupID int
+}
+
+var rootCmd = &cobra.Command{
+	Use:   "dupe-d [directory...]",
+	Short: "dupe-d is a tool to identify file duplicates",
+	Long: `dupe-d is a tool to identify file duplicates by generating sha-256 hash
+	(or another algorithm selected with --algo).
+	To scan the current directory, use: dupe-d .`,
+	Example: `  dupe-d 
+  dupe-d /path/to/directory
+  dupe-d --ext jpg --ext png /path/to/directory
+  dupe-d --ext=jpg,png,pdf
+  dupe-d --size-tolerance 5 /path/to/directory
+  dupe-d --count-only /path/to/directory
+  dupe-d --verify https://example.com/release/hash_results.csv /path/to/release`,
+	Args:          cobra.ArbitraryArgs,
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		err := applyEnvDefaults(cmd)
+		if err != nil {
+			return err
+		}
+
+		err = expandPathFlags()
+		if err != nil {
+			return err
+		}
+
+		err = validateAlgorithm(hashOpts.algo)
+		if err != nil {
+			return err
+		}
+
+		err = validateChunkWorkers(hashOpts.chunkWorkers)
+		if err != nil {
+			return err
+		}
+
+		err = validateCheckpointDir(hashOpts.checkpointDir)
+		if err != nil {
+			return err
+		}
+
+		if hashOpts.length < 0 {
+			return fmt.Errorf("hash length must not be negative: %d", hashOpts.length)
+		}
+
+		if hashOpts.length > 0 {
+			printWarning(fmt.Sprintf("hashes are truncated to %d hex characters, which makes it more likely that different files are reported as duplicates", hashOpts.length))
+		}
+
+		if maxOpenFiles < 1 {
+			return fmt.Errorf("max open files must be at least 1: %d", maxOpenFiles)
+		}
+
+		setMaxOpenFiles(maxOpenFiles)
+
+		fixedTime, err = fixedOutputTime()
+		if err != nil {
+			return err
+		}
+
+		hashOpts.normalizers, err = buildNormalizers(normalize, formatExtensions(normalizeEOL), formatExtensions(trimNulls))
+		return err
+	},
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		// Arguments are valid at this point; further errors are not usage
+		// mistakes, so don't bury them under the help text.
+		cmd.SilenceUsage = true
+
+		if listExtGroups {
+			printExtGroups()
+			return nil
+		}
+
+		exts, err := selectedExtensions()
+		if err != nil {
+			return err
+		}
+
+		// Files selected by --glob alone do not add the current
+		// directory as a default.
+		var folderPaths []string
+		if len(args) > 0 || dirsFile != "" || len(globPatterns) == 0 {
+			folderPaths, err = getFolderPaths(args, dirsFile)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Every recorded path, including archive entries, is built from
+		// one of folderPaths, so making them absolute is enough.
+		if absolutePaths {
+			for i, folderPath := range folderPaths {
+				absPath, err := filepath.Abs(folderPath)
+				if err != nil {
+					return fmt.Errorf("failed to make %s absolute: %w", folderPath, err)
+				}
+
+				folderPaths[i] = absPath
+			}
+		}
+
+		globs, folderPaths, err := addGlobRoots(globPatterns, folderPaths)
+		if err != nil {
+			return err
+		}
+
+		// Verifying and finding duplicate directories work relative to a
+		// single root.
+		if len(folderPaths) > 1 {
+			switch {
+			case verifyManifest != "":
+				return fmt.Errorf("--verify checks a single directory, but %d were given", len(folderPaths))
+			case dirDupes:
+				return fmt.Errorf("--dir-dupes works on a single directory, but %d were given", len(folderPaths))
+			}
+		}
+
+		if sinceLastRun && cacheFilePath == "" {
+			return errors.New("--since-last-run needs --cache-file to record when the last run was")
+		}
+
+		if sizeTolerance < 0 {
+			return fmt.Errorf("size tolerance must not be negative: %g", sizeTolerance)
+		}
+
+		if minGroupSize < 2 {
+			return fmt.Errorf("minimum group size must be at least 2: %d", minGroupSize)
+		}
+
+		if workers < 1 {
+			return fmt.Errorf("workers must be at least 1: %d", workers)
+		}
+
+		if autoWorkers && (cmd.Flags().Changed("workers") || envSetFlags["workers"]) {
+			printWarning(fmt.Sprintf("--workers %d overrides --auto-workers", workers))
+			autoWorkers = false
+		}
+
+		if archiveOpts.maxDepth < 1 {
+			return fmt.Errorf("archive depth must be at least 1: %d", archiveOpts.maxDepth)
+		}
+
+		if countOnly != "" {
+			if countOnly != "groups" && countOnly != "files" {
+				return fmt.Errorf("unknown count mode %q (expected groups or files)", countOnly)
+			}
+
+			quiet = true
+		}
+
+		if listExtensions != "" {
+			err = validateListExtensions(listExtensions)
+			if err != nil {
+				return err
+			}
+		}
+
+		if keepersOnly {
+			err = validateKeepStrategy(keepStrategy)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = validateColumns(columns)
+		if err != nil {
+			return err
+		}
+
+		err = validateDetectCollisions()
+		if err != nil {
+			return err
+		}
+
+		err = validateMatchOn(matchOn)
+		if err != nil {
+			return err
+		}
+
+		if probeFraction <= 0 || probeFraction > 1 {
+			return fmt.Errorf("probe fraction must be greater than 0 and at most 1: %g", probeFraction)
+		}
+
+		err = validateOrder(hashOrder)
+		if err != nil {
+			return err
+		}
+
+		if !slices.Contains(symlinkModes, symlinkMode) {
+			return fmt.Errorf("unknown symlink mode %q (expected one of: %s)", symlinkMode, strings.Join(symlinkModes, ", "))
+		}
+
+		if maxResults < 0 {
+			return fmt.Errorf("max results must not be negative: %d", maxResults)
+		}
+
+		if preview < 0 {
+			return fmt.Errorf("preview row count must not be negative: %d", preview)
+		}
+
+		err = validateStdoutFormat(stdoutFormat)
+		if err != nil {
+			return err
+		}
+
+		// The paths are meant for other programs, so nothing else may be
+		// mixed in with them.
+		if stdoutFormat == "paths" {
+			err = validateKeepStrategy(keepStrategy)
+			if err != nil {
+				return err
+			}
+
+			quiet = true
+		}
+
+		if (print0 || includeKeepers) && stdoutFormat != "paths" {
+			printWarning("--print0 and --include-keepers only apply to --format paths")
+		}
+
+		err = validateOutputFiles(outputFiles)
+		if err != nil {
+			return err
+		}
+
+		err = validateShards(getResultsFilenames())
+		if err != nil {
+			return err
+		}
+
+		if printConfig != "" {
+			err = validatePrintConfig(printConfig)
+			if err != nil {
+				return err
+			}
+
+			return printResolvedConfig(cmd, folderPaths, printConfig)
+		}
+
+		if noOutput && sizeTolerance > 0 {
+			printWarning("--no-output also leaves out the --size-tolerance candidates file")
+		}
+
+		if countOnly == "" && verifyManifest == "" && preview == 0 && !probe && listExtensions == "" && !nameDupes && !noOutput {
+			for _, dir := range getOutputDirs() {
+				err = checkWritable(dir)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		var manifest []HashedFileInfo
+		if verifyManifest != "" {
+			manifest, err = loadManifest(verifyManifest, verifyTimeout)
+			if err != nil {
+				return err
+			}
+
+			err = checkAlgorithm(manifest, verifyManifest, hashOpts.algo)
+			if err != nil {
+				return err
+			}
+		}
+
+		scanOpts := scanOptions{
+			exts:           exts,
+			hash:           hashOpts,
+			archives:       archiveOpts,
+			workers:        workers,
+			autoWorkers:    autoWorkers,
+			globs:          globs,
+			order:          hashOrder,
+			skipVCS:        skipVCS,
+			includeSpecial: includeSpecial,
+			strict:         strict,
+			skipLocked:     skipLocked,
+			symlinkMode:    symlinkMode,
+			stayWithinRoot: stayWithinRoot,
+			maxReads:       int64(maxTotalReads),
+			emptyFiles:     emptyFilesMode(),
+		}
+
+		if skipPattern != "" {
+			scanOpts.skipPattern, err = regexp.Compile(skipPattern)
+			if err != nil {
+				return fmt.Errorf("invalid --skip-files-matching pattern: %w", err)
+			}
+		}
+
+		if oneFileSystem || len(crossDevice) > 0 {
+			scanOpts.devices, err = resolveDevices(crossDevice)
+			if err != nil {
+				return err
+			}
+		}
+
+		if resumeFile != "" {
+			scanOpts.resume, err = loadResumeFile(resumeFile)
+			if err != nil {
+				return err
+			}
+		}
+
+		if cacheFilePath != "" {
+			scanOpts.cache, err = loadHashCache(cacheFilePath, hashOpts)
+			if err != nil {
+				return err
+			}
+		}
+
+		ctx := cmd.Context()
+		if scanTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, scanTimeout)
+			defer cancel()
+		}
+
+		if probe {
+			return probeAccess(ctx, folderPaths, scanOpts, probeFraction)
+		}
+
+		if listExtensions != "" {
+			return printExtensions(ctx, folderPaths, scanOpts, listExtensions)
+		}
+
+		if nameDupes {
+			return printNameDupes(ctx, folderPaths, scanOpts, minGroupSize)
+		}
+
+		// Files modified after a --since-last-run scan starts are checked
+		// again by the next one.
+		var lastRun time.Time
+		var hasLastRun bool
+		started := time.Now()
+
+		if sinceLastRun {
+			lastRun, hasLastRun = scanOpts.cache.lastRun(folderPaths)
+			if !hasLastRun {
+				printToStdOut("No previous run recorded for --since-last-run, reporting all duplicates\n")
+			}
+		}
+
+		// A FIFO or /dev/fd/N works as well as a regular file, so a GUI can
+		// read the events as they are written.
+		if progressFile != "" {
+			events, err := os.Create(progressFile)
+			if err != nil {
+				return fmt.Errorf("failed to create progress file: %w", err)
+			}
+			defer events.Close()
+
+			scanOpts.progressEvents = events
+		}
+
+		hashedFilesInfo, err := processFiles(ctx, folderPaths, scanOpts)
+
+		if sinceLastRun && err == nil {
+			scanOpts.cache.recordRun(folderPaths, started)
+		}
+
+		// The cache is saved even after a partial scan, so the work done
+		// so far is not lost.
+		if scanOpts.cache != nil {
+			saveErr := scanOpts.cache.save()
+			if saveErr != nil {
+				printWarning(saveErr.Error())
+			}
+		}
+
+		var groups [][]HashedFileInfo
+		var written []string
+
+		// The hook runs however the scan ends, with whatever was found and
+		// written by then.
+		if postHook != "" {
+			defer func() {
+				err = runPostHook(postHook, err, hashedFilesInfo, groups, written)
+			}()
+		}
+
+		// A scan stopped by a timeout or Ctrl-C, or one where some files
+		// could not be hashed, still returns the files it finished. Those
+		// are reported as usual and the reason the results are incomplete
+		// is returned at the end.
+		var incomplete error
+		var scanErrs *ScanErrors
+
+		switch {
+		case errors.Is(err, errReadsCapped) || err != nil && ctx.Err() != nil:
+			if verifyManifest != "" || countOnly != "" {
+				return scanStoppedError(err)
+			}
+
+			printWarning(fmt.Sprintf("%s, writing partial results for %d files", scanStoppedReason(err), len(hashedFilesInfo)))
+			incomplete = scanStoppedError(err)
+		case errors.As(err, &scanErrs):
+			incomplete = scanErrs
+		case err != nil:
+			return err
+		}
+
+		if verifyManifest != "" {
+			err = verifyAgainstManifest(folderPaths[0], hashedFilesInfo, manifest)
+			if err != nil {
+				return err
+			}
+
+			return incomplete
+		}
+
+		if len(hashedFilesInfo) == 0 && incomplete == nil && !allowEmpty && countOnly == "" {
+			return noFilesError(scanOpts)
+		}
+
+		groupable := hashedFilesInfo
+		if scanOpts.emptyFiles == emptyUnique {
+			groupable = withoutEmptyFiles(hashedFilesInfo)
+		}
+
+		groups = findDuplicateGroups(groupable, minGroupSize, matchOn)
+
+		if detectCollisions {
+			var stats collisionStats
+
+			groups, stats, err = confirmGroups(ctx, groups, minGroupSize)
+			if err != nil {
+				return scanStoppedError(err)
+			}
+
+			printCollisionStats(stats)
+
+			// Results files still list colliding files under the same
+			// hash, so the run fails to make sure they are noticed.
+			if stats.collisions > 0 && incomplete == nil {
+				incomplete = fmt.Errorf("found %d hash collisions; the colliding files are not reported as duplicates, but still share a hash in the results", stats.collisions)
+			}
+		}
+
+		groups = filterBySavings(groups, int64(minSavings))
+
+		if hasLastRun {
+			groups = groupsChangedSince(groups, lastRun)
+			printToStdOut(fmt.Sprintf("Reporting duplicates involving files modified since the last run at %s\n", lastRun.Format(time.RFC3339)))
+		}
+
+		if countOnly != "" {
+			printCount(groups, countOnly)
+			return incomplete
+		}
+
+		results := hashedFilesInfo
+		switch {
+		case keepersOnly:
+			results = keeperFiles(hashedFilesInfo, groups, keepStrategy, canonicalDir)
+		case uniquesOnly:
+			results = uniqueFiles(hashedFilesInfo, findDuplicateGroups(groupable, 2, matchOn))
+		case duplicatesOnly:
+			results = duplicateFiles(hashedFilesInfo, groups)
+		}
+
+		if hasLastRun {
+			results = filesChangedSince(results, groups, lastRun)
+		}
+
+		groups = capResults(groups, "duplicate groups")
+
+		if preview > 0 {
+			err = printPreview(results, preview)
+			if err != nil {
+				return err
+			}
+
+			return incomplete
+		}
+
+		assignGroupIDs(results, groups)
+
+		if !noOutput {
+			written, err = writeResults(results)
+			if err != nil {
+				return err
+			}
+		}
+
+		if sizeTolerance > 0 && !noOutput {
+			candidates := capResults(findNearDuplicateCandidates(hashedFilesInfo, sizeTolerance), "near-duplicate candidate groups")
+
+			err = writeCandidatesToCsv(candidates)
+			if err != nil {
+				return err
+			}
+		}
+
+		stdoutFormats[stdoutFormat](hashedFilesInfo, groups)
+
+		if dirDupes {
+			dirGroups, err := findDuplicateDirs(folderPaths[0], hashedFilesInfo, hashOpts.algo)
+			if err != nil {
+				return err
+			}
+
+			printDuplicateDirs(dirGroups)
+		}
+
+		return incomplete
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Suppress progress and informational output")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Report every file that is skipped and why")
+	rootCmd.MarkFlagsMutuallyExclusive("quiet", "verbose")
+	rootCmd.PersistentFlags().BoolVar(&deterministic, "deterministic", false, "Make identical scans produce byte-identical output: no timestamp in default file names and a fixed generated_at in JSON")
+	rootCmd.PersistentFlags().BoolVar(&utcTimestamps, "utc-timestamps", false, "Use UTC rather than local time in timestamped output file names (marked with a Z) and in the JSON generated_at")
+	rootCmd.PersistentFlags().BoolVar(&noProgress, "no-progress", false, "Hide progress updates but keep other informational output")
+	rootCmd.PersistentFlags().StringVar(&hashOpts.algo, "algo", defaultAlgorithm, "Hash algorithm: "+strings.Join(algorithmNames(), ", ")+", each optionally followed by "+treeSuffix+" to hash large files in chunks that can be read in parallel")
+	rootCmd.PersistentFlags().IntVar(&hashOpts.chunkWorkers, "hash-concurrency-per-file", 1, "With a "+treeSuffix+" algorithm, hash this many chunks of a large file at a time")
+	rootCmd.PersistentFlags().StringVar(&hashOpts.checkpointDir, "checkpoint-dir", "", "Save the hash state of files over 256 MB to this directory as they are read, so an interrupted hash resumes where it stopped")
+	rootCmd.PersistentFlags().IntVar(&hashOpts.length, "hash-length", 0, "Store only the first N hex characters of each hash (default: full hash)")
+	rootCmd.PersistentFlags().IntVar(&maxOpenFiles, "max-open-files", defaultMaxOpenFiles(), "Maximum number of files open for hashing at the same time")
+	rootCmd.PersistentFlags().StringSliceVar(&normalize, "normalize", []string{}, "Hash only the meaningful content of supported file types: "+strings.Join(normalizerNames(), ", "))
+	rootCmd.PersistentFlags().StringSliceVar(&trimNulls, "trim-trailing-nulls", []string{}, "File extensions (e.g. img,bin) whose trailing NUL padding is left out of the hash")
+	rootCmd.PersistentFlags().StringSliceVar(&normalizeEOL, "normalize-eol", []string{}, "Text file extensions (e.g. txt,go,md) whose CRLF and CR line endings are hashed as LF")
+	rootCmd.PersistentFlags().BoolVar(&hashOpts.decompress, "decompress-compare", false, "Hash the decompressed content of .gz, .bz2 and .zst files so differently compressed copies are duplicates")
+	rootCmd.PersistentFlags().BoolVar(&hashOpts.includeName, "hash-include-name", false, "Include the file name in the hash so same-content files with different names are distinct")
+	rootCmd.PersistentFlags().BoolVar(&hashOpts.includeMode, "hash-include-mode", false, "Include the file mode in the hash so same-content files with different permissions are distinct")
+	rootCmd.Flags().StringSliceVarP(&extensions, "ext", "e", []string{}, "File extensions to process (can be specified multiple times or comma-separated)")
+	rootCmd.Flags().StringVar(&stdoutFormat, "format", "summary", "How to show the results on stdout after writing the output files: "+strings.Join(stdoutFormatNames(), ", "))
+	rootCmd.Flags().BoolVar(&noColor, "no-color", false, "Disable colored output")
+	rootCmd.Flags().BoolVar(&probe, "probe", false, "Only check that the files to scan can be read, without hashing them")
+	rootCmd.Flags().Float64Var(&probeFraction, "probe-fraction", 0.1, "Fraction of files --probe opens (directories are always checked)")
+	rootCmd.Flags().IntVar(&preview, "preview", 0, "Print the first N rows of the results to stdout instead of writing the output file")
+	rootCmd.Flags().StringVar(&countOnly, "count-only", "", "Print only the number of duplicate groups (or redundant files with --count-only=files) and write no output file")
+	rootCmd.Flags().Lookup("count-only").NoOptDefVal = "groups"
+	rootCmd.Flags().BoolVar(&dirDupes, "dir-dupes", false, "Also report directories whose entire contents are duplicated")
+	rootCmd.Flags().BoolVar(&keepersOnly, "keepers-only", false, "Write only the file kept from each duplicate group plus every unique file")
+	rootCmd.Flags().BoolVar(&uniquesOnly, "uniques-only", false, "Write only the files that have no duplicate anywhere in the scan")
+	rootCmd.Flags().BoolVar(&duplicatesOnly, "duplicates-only", false, "Write only the files that belong to a reported duplicate group")
+	rootCmd.MarkFlagsMutuallyExclusive("keepers-only", "uniques-only", "duplicates-only")
+	rootCmd.Flags().StringVar(&keepStrategy, "keep", "first", "Which file --keepers-only and --format paths keep in each group: "+strings.Join(keepStrategies, ", "))
+	rootCmd.Flags().StringVar(&canonicalDir, "canonical-dir", "", "Prefer keeping files inside this directory with --keepers-only and --format paths")
+	rootCmd.Flags().IntVar(&minGroupSize, "min-group-size", 2, "Minimum number of identical files for them to count as a duplicate group")
+	rootCmd.Flags().StringVar(&matchOn, "match-on", matchHash, "What files must share to be duplicates: "+strings.Join(matchModes, ", "))
+	rootCmd.Flags().BoolVar(&zeroAsUnique, "include-zero-size-as-unique", false, "List empty files in the results without ever grouping them as duplicates")
+	rootCmd.Flags().BoolVar(&groupZeroSize, "group-zero-size", false, "Treat all empty files as one duplicate group")
+	rootCmd.MarkFlagsMutuallyExclusive("include-zero-size-as-unique", "group-zero-size")
+	rootCmd.Flags().IntVar(&maxResults, "max-results", 0, "Report at most this many duplicate groups (default: no limit)")
+	rootCmd.Flags().Var(&minSavings, "min-savings", "Only report duplicate groups that would free at least this much space (e.g. 100MB)")
+	rootCmd.Flags().DurationVar(&scanTimeout, "timeout", 0, "Stop the scan after this long (e.g. 5m) and write the results gathered so far")
+	rootCmd.Flags().StringVar(&progressFile, "progress-file", "", "Write progress events as JSON lines to this file, FIFO or /dev/fd/N, separately from the output on stdout")
+	rootCmd.Flags().Var(&maxTotalReads, "max-total-reads", "Stop hashing once this many bytes (e.g. 50GB) have been read from files and write the results gathered so far")
+	rootCmd.Flags().BoolVar(&strict, "strict", false, "Stop at the first file that cannot be read or hashed instead of reporting failures at the end")
+	rootCmd.Flags().BoolVar(&skipLocked, "skip-locked", false, "Skip files that are locked or in use by another process instead of reporting them as failures")
+	rootCmd.Flags().IntVar(&workers, "workers", 1, "Number of files to hash concurrently")
+	rootCmd.Flags().StringArrayVarP(&outputFiles, "output", "o", []string{}, "Write the results to this file instead of a timestamped CSV in the current directory; the format follows the extension ("+strings.Join(outputFormatNames(), ", ")+") and the flag can be repeated")
+	rootCmd.Flags().BoolVar(&csvBOM, "csv-bom", false, "Start CSV files with a UTF-8 byte order mark so spreadsheet applications such as Excel detect the encoding")
+	rootCmd.Flags().BoolVar(&gzipOutput, "gzip", false, "Gzip the timestamped output files (an --output name ending in .gz is always compressed)")
+	rootCmd.Flags().BoolVar(&legacyJson, "legacy-json", false, "Write JSON results as a bare array instead of the versioned envelope")
+	rootCmd.Flags().StringVar(&outputDir, "output-dir", "", "Directory to write timestamped output files to (default: current directory)")
+	rootCmd.MarkFlagsMutuallyExclusive("output", "output-dir")
+	rootCmd.Flags().Var(&maxOutputSize, "max-output-size", "Split CSV results into numbered files of at most this size each (e.g. 100MB), each with its own header")
+	rootCmd.Flags().BoolVar(&allowEmpty, "allow-empty", false, "Write the results even when no files matched, instead of failing")
+	rootCmd.Flags().BoolVar(&noOutput, "no-output", false, "Write no results file, only show the results on stdout")
+	rootCmd.MarkFlagsMutuallyExclusive("no-output", "output")
+	rootCmd.MarkFlagsMutuallyExclusive("no-output", "output-dir")
+	rootCmd.Flags().BoolVar(&absolutePaths, "absolute", false, "Record absolute paths even when the directory is given as a relative path")
+	rootCmd.Flags().StringVar(&resumeFile, "resume", "", "Reuse hashes from a previous (possibly partial) results CSV for files whose size and modification time are unchanged")
+	rootCmd.Flags().StringVar(&cacheFilePath, "cache-file", "", "Reuse and record hashes in this cache file, which can be shared between scans of different directories")
+	rootCmd.Flags().BoolVar(&sinceLastRun, "since-last-run", false, "Only report duplicates involving files modified since the last complete --since-last-run scan of the same directories, as recorded in --cache-file")
+	rootCmd.Flags().StringVar(&skipPattern, "skip-files-matching", "", "Skip files whose full path matches this regular expression")
+	rootCmd.Flags().StringVar(&dirsFile, "dirs-file", "", "Also scan the directories listed in this file, one per line (blank lines and # comments are ignored)")
+	rootCmd.Flags().BoolVar(&oneFileSystem, "one-file-system", false, "Do not descend into directories on other file systems than the scanned directory")
+	rootCmd.Flags().StringSliceVar(&crossDevice, "cross-device-allow", nil, "Also descend into the file systems holding these paths; implies --one-file-system")
+	rootCmd.Flags().BoolVar(&skipVCS, "skip-vcs", false, "Skip version-control metadata directories ("+strings.Join(vcsDirs, ", ")+")")
+	rootCmd.Flags().BoolVar(&stayWithinRoot, "stay-within-root", true, "With --symlink-mode follow, skip symlinks whose target lies outside the scanned directories (--stay-within-root=false follows them)")
+	rootCmd.Flags().StringVar(&symlinkMode, "symlink-mode", symlinkSkip, "What to do with symlinks: skip them, record them with their target but no hash, or follow them and hash the target")
+	rootCmd.Flags().BoolVar(&includeSpecial, "include-special", false, "Also hash FIFOs, sockets and device files instead of skipping them")
+	rootCmd.Flags().BoolVar(&archiveOpts.enabled, "scan-archives", false, "Also hash the files inside zip, tar and tar.gz archives")
+	rootCmd.Flags().IntVar(&archiveOpts.maxDepth, "archive-depth", 1, "How many levels of archives inside archives to open with --scan-archives")
+	rootCmd.Flags().Int64Var(&archiveOpts.maxBytes, "archive-max-bytes", 1<<30, "Maximum uncompressed bytes to read from a single archive, including nested archives")
+	rootCmd.Flags().BoolVar(&groupSep, "group-separator", false, "List CSV results group by group, with a blank row between groups")
+	rootCmd.Flags().Float64Var(&sizeTolerance, "size-tolerance", 0, "Also report near-duplicate candidates whose sizes are within this percentage of each other")
+}
+
+func main() {
+	// Ctrl-C cancels the context, which stops a running scan promptly.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		printToStdErr(err)
+		stop()
+		os.Exit(exitCode(err))
+	}
+}
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return exitTimedOut
+	case errors.Is(err, errReadsCapped):
+		return exitCapped
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	}
+
+	return 1
+}
+
+func scanStoppedReason(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Sprintf("scan timed out after %s", scanTimeout)
+	}
+	if errors.Is(err, errReadsCapped) {
+		return fmt.Sprintf("scan stopped after reading %s (--max-total-reads)", formatBytes(int64(maxTotalReads)))
+	}
+
+	return "scan interrupted"
+}
+
+func scanStoppedError(err error) error {
+	return fmt.Errorf("%s, results are partial: %w", scanStoppedReason(err), err)
+}
+
+// getFolderPaths returns the directories to scan: those given as arguments
+// followed by those listed in dirsFile, or the current directory if there
+// are none.
+func getFolderPaths(args []string, dirsFile string) ([]string, error) {
+	paths := append([]string{}, args...)
+
+	if dirsFile != "" {
+		listed, err := readDirsFile(dirsFile)
+		if err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, listed...)
+	}
+
+	if len(paths) == 0 {
+		currentDir, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+
+		return []string{currentDir}, nil
+	}
+
+	folderPaths := make([]string, 0, len(paths))
+	for _, path := range paths {
+		folderPath, err := validateDirectory(path)
+		if errors.Is(err, errFileNotDirectory) {
+			return nil, fmt.Errorf("%w; scans take directories, so use \"dupe-d hash %s\" to hash a single file", err, path)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		folderPath = filepath.Clean(folderPath)
+		if !slices.Contains(folderPaths, folderPath) {
+			folderPaths = append(folderPaths, folderPath)
+		}
+	}
+
+	return folderPaths, nil
+}
+
+// readDirsFile reads the directories listed in path, one per line. Blank
+// lines and lines starting with # are ignored.
+func readDirsFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory list: %w", err)
+	}
+
+	var dirs []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		dirs = append(dirs, line)
+	}
+
+	return dirs, nil
+}
+
+func validateDirectory(path string) (string, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("directory not accessible: %w", err)
+	}
+
+	if info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is a file, %w", path, errFileNotDirectory)
+	}
+
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", path)
+	}
+
+	return path, nil
+}
+
+// emptyFilesMode returns how the scan treats zero-byte files. Verifying
+// against a manifest needs every file, so empty ones are kept there.
+func emptyFilesMode() string {
+	switch {
+	case groupZeroSize:
+		return emptyGroup
+	case zeroAsUnique || verifyManifest != "":
+		return emptyUnique
+	}
+
+	return emptySkip
+}
+
+// noFilesError explains a scan that found nothing to hash, naming the
+// filters that may have left every file out.
+func noFilesError(opts scanOptions) error {
+	var filters []string
+	if len(opts.exts) > 0 {
+		filters = append(filters, "extensions "+strings.Join(opts.exts, ", "))
+	}
+	for _, pattern := range globPatterns {
+		filters = append(filters, "--glob "+pattern)
+	}
+	if skipPattern != "" {
+		filters = append(filters, "--skip-files-matching "+skipPattern)
+	}
+	if opts.skipVCS {
+		filters = append(filters, "--skip-vcs")
+	}
+	if oneFileSystem || len(crossDevice) > 0 {
+		filters = append(filters, "--one-file-system")
+	}
+
+	if len(filters) == 0 && opts.emptyFiles == emptySkip {
+		return errors.New("no files found to scan (empty files are skipped unless --include-zero-size-as-unique or --group-zero-size is given); use --allow-empty to write empty results anyway")
+	}
+	if len(filters) == 0 {
+		return errors.New("no files found to scan; use --allow-empty to write empty results anyway")
+	}
+
+	return fmt.Errorf("no files matched your filters (%s); use --allow-empty to write empty results anyway", strings.Join(filters, "; "))
+}
+
+// resolveDevices returns the devices a --one-file-system walk may enter
+// besides the one holding the directory being walked: those holding each of
+// the allowed paths. It returns nil where devices cannot be told apart.
+func resolveDevices(allowed []string) (map[uint64]bool, error) {
+	devices := make(map[uint64]bool)
+
+	for _, path := range allowed {
+		device, ok, err := deviceOf(path)
+		if err != nil || !ok {
+			return nil, err
+		}
+
+		devices[device] = true
+	}
+
+	return devices, nil
+}
+
+// deviceOf returns the device holding path. It warns and reports false
+// where devices cannot be told apart.
+func deviceOf(path string) (uint64, bool, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return 0, false, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to resolve device of %s: %w", path, err)
+	}
+
+	device, ok := deviceID(path, info)
+	if !ok {
+		printWarning("devices cannot be told apart on this platform, so --one-file-system has no effect")
+	}
+
+	return device, ok, nil
+}
+
+// onAllowedDevice reports whether the directory at path is on one of
+// devices. Directories whose device cannot be determined are entered.
+func onAllowedDevice(path string, d fs.DirEntry, devices map[uint64]bool) bool {
+	info, err := d.Info()
+	if err != nil {
+		return true
+	}
+
+	device, ok := deviceID(path, info)
+	return !ok || devices[device]
+}
+
+// expandHome replaces a leading "~" in path with the user's home directory,
+// for shells that leave it alone (such as a quoted argument, or Windows).
+// "~user" forms are left as they are.
+func expandHome(path string) (string, error) {
+	if path != "~" && !(len(path) > 1 && path[0] == '~' && os.IsPathSeparator(path[1])) {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to expand %s: %w", path, err)
+	}
+
+	return filepath.Join(home, path[1:]), nil
+}
+
+// expandPathFlags applies expandHome to every flag that takes a file or
+// directory path.
+func expandPathFlags() error {
+	paths := []*string{&outputDir, &resumeFile, &cacheFilePath, &canonicalDir, &mergeOutput, &progressFile, &hashOpts.checkpointDir, &dirsFile}
+	for i := range outputFiles {
+		paths = append(paths, &outputFiles[i])
+	}
+
+	if !isURL(verifyManifest) {
+		paths = append(paths, &verifyManifest)
+	}
+
+	for _, path := range paths {
+		expanded, err := expandHome(*path)
+		if err != nil {
+			return err
+		}
+
+		*path = expanded
+	}
+
+	return nil
+}
+
+func formatExtensions(rawExts []string) []string {
+	var formattedExts []string
+
+	for _, ext := range rawExts {
+		splitExts := strings.Split(ext, ",")
+
+		for _, splitExt := range splitExts {
+			splitExt = strings.TrimSpace(splitExt)
+
+			if splitExt == "" {
+				continue
+			}
+
+			if !strings.HasPrefix(splitExt, ".") {
+				splitExt = "." + splitExt
+			}
+
+			formattedExts = append(formattedExts, splitExt)
+		}
+	}
+
+	return formattedExts
+}
+
+// processFiles walks folderPaths and hashes the selected files. If ctx is
+// canceled it stops as soon as possible and returns the files hashed so far
+// along with ctx.Err().
+func processFiles(ctx context.Context, folderPaths []string, opts scanOptions) ([]HashedFileInfo, error) {
+	for _, folderPath := range folderPaths {
+		printToStdOut(fmt.Sprintf("Scanning folder: %s\n", folderPath))
+	}
+	if len(opts.exts) > 0 {
+		printToStdOut(fmt.Sprintf("Filtering by extensions: %s\n", strings.Join(opts.exts, ", ")))
+	} else {
+		printToStdOut("Processing all file types\n")
+	}
+
+	if opts.skipPattern != nil {
+		printToStdOut(fmt.Sprintf("Skipping paths matching: %s\n", opts.skipPattern))
+	}
+
+	if len(globPatterns) > 0 {
+		printToStdOut(fmt.Sprintf("Selecting files matching: %s\n", strings.Join(globPatterns, ", ")))
+	}
+
+	candidates, failures, err := collectRoots(ctx, folderPaths, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var totalBytes int64
+	for _, candidate := range candidates {
+		totalBytes += candidate.Size
+	}
+
+	printToStdOut(fmt.Sprintf("Found %d files to hash (%s)\n", len(candidates), formatBytes(totalBytes)))
+
+	// The digest of a zero-byte file only depends on the settings, unless
+	// the name or mode is part of it. Special files report a size of zero
+	// whatever they hold, so they always have to be read.
+	if !opts.hash.includeName && !opts.hash.includeMode && !opts.includeSpecial {
+		opts.emptyHash, err = hashReader(ctx, strings.NewReader(""), "", 0, opts.hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Reaching --max-total-reads cancels the hashing like a timeout, but
+	// only for this scan, so that the results can still be checked with
+	// --detect-collisions.
+	ctx, stopReading := context.WithCancelCause(ctx)
+	defer stopReading(nil)
+
+	progress := newProgressTracker(len(candidates), totalBytes)
+	if opts.maxReads > 0 {
+		progress.limitReads(opts.maxReads, func() { stopReading(errReadsCapped) })
+	}
+	if opts.progressEvents != nil {
+		progress.writeEventsTo(opts.progressEvents)
+	}
+
+	// Each candidate's results go in its own slot so the output order does
+	// not depend on which worker finishes first.
+	results := make([][]HashedFileInfo, len(candidates))
+	candidateErrs := make([]error, len(candidates))
+	lockedFiles := make([]bool, len(candidates))
+	vanishedFiles := make([]bool, len(candidates))
+
+	order := dispatchOrder(candidates, opts.order)
+
+	hashCandidate := func(next int) error {
+		i := order[next]
+		entries, err := processCandidate(ctx, candidates[i], opts, progress)
+		results[i] = entries
+		progress.fileDone(candidates[i].Path)
+
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		if opts.skipLocked && isLockedError(err) {
+			printVerbose(fmt.Sprintf("Skipped: %s (locked by another process, --skip-locked)\n", candidates[i].Path))
+			lockedFiles[i] = true
+			return nil
+		}
+
+		// Temporary files are routinely removed on a live system, so a
+		// file deleted after the walk found it is not a failure.
+		if errors.Is(err, fs.ErrNotExist) {
+			printVerbose(fmt.Sprintf("Skipped: %s (disappeared during scan)\n", candidates[i].Path))
+			vanishedFiles[i] = true
+			return nil
+		}
+
+		if opts.strict {
+			return FileError{Path: candidates[i].Path, Err: err}
+		}
+
+		candidateErrs[i] = err
+		return nil
+	}
+
+	if opts.autoWorkers {
+		err = runAutoWorkers(ctx, len(candidates), progress, hashCandidate)
+	} else {
+		err = runWorkers(ctx, len(candidates), opts.workers, hashCandidate)
+	}
+	progress.finishEvents()
+
+	if err != nil && ctx.Err() == nil {
+		return nil, err
+	}
+
+	var files []HashedFileInfo
+	var locked, vanished int
+	for i, entries := range results {
+		files = append(files, entries...)
+
+		if lockedFiles[i] {
+			locked++
+		}
+
+		if vanishedFiles[i] {
+			vanished++
+		}
+
+		if candidateErrs[i] != nil {
+			failures = append(failures, FileError{Path: candidates[i].Path, Err: candidateErrs[i]})
+		}
+	}
+
+	if locked > 0 {
+		printToStdOut(fmt.Sprintf("Skipped %d files locked by other processes\n", locked))
+	}
+
+	if vanished > 0 {
+		printToStdOut(fmt.Sprintf("Skipped %d files that disappeared during the scan\n", vanished))
+	}
+
+	markLinkedFiles(files)
+
+	// Files finished before a cancellation are still returned so callers
+	// can keep partial results.
+	if ctx.Err() != nil {
+		return files, context.Cause(ctx)
+	}
+
+	if len(failures) > 0 {
+		return files, &ScanErrors{errs: failures}
+	}
+
+	return files, nil
+}
+
+// processCandidate hashes a single walked file and, when archive scanning is
+// enabled, the entries of the archive it contains.
+func processCandidate(ctx context.Context, candidate HashedFileInfo, opts scanOptions, progress *progressTracker) ([]HashedFileInfo, error) {
+	var files []HashedFileInfo
+
+	previous, resumed := opts.resume[candidate.Path]
+	resumed = resumed && isUnchanged(previous, candidate)
+
+	if !resumed && opts.cache != nil {
+		if cached, ok := opts.cache.lookup(candidate); ok {
+			previous = HashedFileInfo{Hash: cached.Hash, RawHash: cached.RawHash}
+			resumed = true
+		}
+	}
+
+	// Recorded symlinks keep their target in LinkedTo and are not hashed.
+	if candidate.Symlink && opts.symlinkMode == symlinkRecord {
+		if progress.due() {
+			printProgress(fmt.Sprintf("Recording symlink: %s -> %s\n", candidate.Path, candidate.LinkedTo))
+		}
+		return []HashedFileInfo{candidate}, nil
+	}
+
+	if progress.due() {
+		if resumed {
+			printProgress(fmt.Sprintf("Reusing hash: %s [%s]\n", candidate.Path, progress.status()))
+		} else {
+			printProgress(fmt.Sprintf("Processing: %s [%s]\n", candidate.Path, progress.status()))
+		}
+	}
+
+	fileProgress := progress.forFile(candidate.Path)
+
+	if matchesExtension(candidate.Path, opts.exts) {
+		if resumed {
+			candidate.Hash = previous.Hash
+			candidate.RawHash = previous.RawHash
+		} else if opts.hash.decompress && isCompressed(candidate.Path) {
+			err := hashCompressedFile(ctx, &candidate, opts.hash, fileProgress.update)
+			if err != nil {
+				return nil, err
+			}
+		} else if candidate.Size == 0 && opts.emptyHash != "" {
+			candidate.Hash = opts.emptyHash
+		} else {
+			hash, err := hashFile(ctx, candidate.Path, opts.hash, fileProgress.update)
+			if err != nil {
+				return nil, fmt.Errorf("failed to hash file: %w", err)
+			}
+
+			candidate.Hash = hash
+		}
+
+		if !resumed && opts.cache != nil {
+			opts.cache.store(candidate)
+		}
+
+		files = append(files, candidate)
+	}
+
+	if opts.archives.enabled && isArchive(candidate.Path) {
+		entries, err := scanArchive(ctx, candidate.Path, opts)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			printToStdOut(fmt.Sprintf("Skipping contents of %s: %s\n", candidate.Path, err))
+		}
+
+		files = append(files, entries...)
+	}
+
+	fileProgress.finish(candidate.Size)
+
+	return files, nil
+}
+
+// hashCompressedFile records both the raw and the decompressed hash of a
+// compressed file. A file that cannot be decompressed, e.g. because it is
+// truncated, is compared by its raw bytes instead.
+func hashCompressedFile(ctx context.Context, file *HashedFileInfo, opts hashOptions, onRead func(done int64, total int64)) error {
+	rawOpts := opts
+	rawOpts.decompress = false
+
+	rawHash, err := hashFile(ctx, file.Path, rawOpts, onRead)
+	if err != nil {
+		return fmt.Errorf("failed to hash file: %w", err)
+	}
+
+	hash, err := hashFile(ctx, file.Path, opts, onRead)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		printWarning(fmt.Sprintf("comparing %s by its raw bytes: %s", file.Path, err))
+		file.Hash = rawHash
+		return nil
+	}
+
+	file.Hash = hash
+	file.RawHash = rawHash
+
+	return nil
+}
+
+// loadResumeFile reads a results CSV written by an earlier scan and indexes
+// its rows by path.
+func loadResumeFile(path string) (map[string]HashedFileInfo, error) {
+	files, err := readResultsCsv(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkAlgorithm(files, path, hashOpts.algo)
+	if err != nil {
+		return nil, err
+	}
+
+	resume := make(map[string]HashedFileInfo, len(files))
+	for _, file := range files {
+		if file.ModTime.IsZero() {
+			return nil, fmt.Errorf("%s has no Size (bytes) and Modified columns and cannot be resumed from", path)
+		}
+
+		resume[file.Path] = file
+	}
+
+	printToStdOut(fmt.Sprintf("Resuming with %d previously hashed files from %s\n", len(resume), path))
+
+	return resume, nil
+}
+
+func isUnchanged(previous HashedFileInfo, current HashedFileInfo) bool {
+	return previous.Size == current.Size && previous.ModTime.Equal(current.ModTime)
+}
+
+// markLinkedFiles sets LinkedTo on entries that refer to the same underlying
+// file as another entry. A regular file is preferred as the entry the others
+// point at, so a symlink is never reported as the original.
+func markLinkedFiles(files []HashedFileInfo) {
+	representatives := make(map[string]int)
+
+	for i, file := range files {
+		if file.FileID == "" {
+			continue
+		}
+
+		current, ok := representatives[file.FileID]
+		if !ok || (files[current].Symlink && !file.Symlink) {
+			representatives[file.FileID] = i
+		}
+	}
+
+	for i, file := range files {
+		if file.FileID == "" {
+			continue
+		}
+
+		if representative := representatives[file.FileID]; representative != i {
+			files[i].LinkedTo = files[representative].Path
+		}
+	}
+}
+
+// linkDescription describes how a file relates to another scanned entry
+// for the same underlying file, or "" if it is an independent file.
+func linkDescription(file HashedFileInfo) string {
+	if file.LinkedTo == "" {
+		return ""
+	}
+
+	if file.Symlink {
+		return "symlink to " + file.LinkedTo
+	}
+
+	return "hard link to " + file.LinkedTo
+}
+
+// parseLinkDescription reads back a description written by linkDescription,
+// returning whether it names a symlink and the path it links to. Anything
+// else describes an independent file.
+func parseLinkDescription(description string) (bool, string) {
+	if target, ok := strings.CutPrefix(description, "symlink to "); ok {
+		return true, target
+	}
+
+	if target, ok := strings.CutPrefix(description, "hard link to "); ok {
+		return false, target
+	}
+
+	return false, ""
+}
+
+// collectRoots collects the files of each of folderPaths in turn. A file
+// reached through more than one of them, because one lies inside another,
+// is only listed once.
+func collectRoots(ctx context.Context, folderPaths []string, opts scanOptions) ([]HashedFileInfo, []FileError, error) {
+	var files []HashedFileInfo
+	var failures []FileError
+	seen := make(map[string]bool)
+
+	if opts.symlinkMode == symlinkFollow && opts.stayWithinRoot {
+		opts.roots = nil
+		for _, folderPath := range folderPaths {
+			root, err := filepath.EvalSymlinks(folderPath)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to resolve %s: %w", folderPath, err)
+			}
+
+			opts.roots = append(opts.roots, root)
+		}
+	}
+
+	for _, folderPath := range folderPaths {
+		rootOpts := opts
+
+		// A --one-file-system walk stays on the device of its own root.
+		if opts.devices != nil {
+			device, ok, err := deviceOf(folderPath)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			rootOpts.devices = nil
+			if ok {
+				rootOpts.devices = maps.Clone(opts.devices)
+				rootOpts.devices[device] = true
+			}
+		}
+
+		found, failed, err := collectFiles(ctx, folderPath, rootOpts)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, file := range found {
+			if !seen[file.Path] {
+				seen[file.Path] = true
+				files = append(files, file)
+			}
+		}
+
+		failures = append(failures, failed...)
+	}
+
+	return files, failures, nil
+}
+
+// collectFiles walks folderPath and returns the files that should be hashed,
+// with everything except the hash filled in. Knowing the total size up front
+// lets the hashing pass report byte-based progress. Archives are included
+// regardless of the extension filter when their contents are to be scanned.
+// Unless opts.strict is set, entries that cannot be read are returned as
+// failures and the walk carries on.
+func collectFiles(ctx context.Context, folderPath string, opts scanOptions) ([]HashedFileInfo, []FileError, error) {
+	var files []HashedFileInfo
+	var failures []FileError
+
+	// Walking a huge tree can take a while before any hashing starts, so
+	// the number of files found so far is reported now and then.
+	reportedAt := time.Now()
+
+	// linkParents holds the resolved directories containing the directory
+	// symlinks followed to reach the part of the tree being walked.
+	var linkParents []string
+
+	var visit fs.WalkDirFunc
+	var followDirLink func(path string, target string) error
+
+	visit = func(path string, d fs.DirEntry, err error) error {
+		if now := time.Now(); now.Sub(reportedAt) >= etaInterval {
+			reportedAt = now
+			printProgress(fmt.Sprintf("Discovered %d files...\n", len(files)))
+		}
+
+		if err != nil {
+			if opts.strict || path == folderPath {
+				return err
+			}
+
+			failures = append(failures, FileError{Path: path, Err: err})
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// Paths are matched against --glob patterns relative to the root
+		// they were split at.
+		patterns := opts.globs[folderPath]
+		rel, _ := filepath.Rel(folderPath, path)
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if patterns != nil && path != folderPath && !matchesGlob(rel, patterns, true) {
+				return filepath.SkipDir
+			}
+
+			if opts.skipVCS && path != folderPath && slices.Contains(vcsDirs, d.Name()) {
+				printVerbose(fmt.Sprintf("Skipped: %s (version-control directory, --skip-vcs)\n", path))
+				return filepath.SkipDir
+			}
+
+			if opts.devices != nil && path != folderPath && !onAllowedDevice(path, d, opts.devices) {
+				printVerbose(fmt.Sprintf("Skipped: %s (on another file system, --one-file-system)\n", path))
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 && opts.symlinkMode == symlinkFollow {
+			target, err := filepath.EvalSymlinks(path)
+			if err == nil {
+				if info, err := os.Stat(target); err == nil && info.IsDir() {
+					return followDirLink(path, target)
+				}
+			}
+		}
+
+		if patterns != nil && !matchesGlob(rel, patterns, false) {
+			return nil
+		}
+
+		if isSkipped(path, opts) {
+			printVerbose(fmt.Sprintf("Skipped: %s (matches --skip-files-matching)\n", path))
+			return nil
+		}
+
+		if !matchesExtension(path, opts.exts) && !(opts.archives.enabled && isArchive(path)) {
+			printVerbose(fmt.Sprintf("Skipped: %s (extension not selected by --ext)\n", path))
+			return nil
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			switch opts.symlinkMode {
+			case symlinkSkip:
+				printVerbose(fmt.Sprintf("Skipped: %s (symlink, use --symlink-mode to record or follow it)\n", path))
+				return nil
+			case symlinkRecord:
+				target, err := os.Readlink(path)
+				if err != nil {
+					if opts.strict {
+						return fmt.Errorf("failed to read symlink %s: %w", path, err)
+					}
+
+					failures = append(failures, FileError{Path: path, Err: fmt.Errorf("failed to read symlink: %w", err)})
+					return nil
+				}
+
+				fileInfo := HashedFileInfo{Name: d.Name(), Path: path, Symlink: true, LinkedTo: target}
+				if linkInfo, err := d.Info(); err == nil {
+					fileInfo.ModTime = linkInfo.ModTime()
+				}
+
+				files = append(files, fileInfo)
+				return nil
+			case symlinkFollow:
+				// A broken symlink fails to resolve here and is reported
+				// by the os.Stat below.
+				target, err := filepath.EvalSymlinks(path)
+				if err == nil && opts.roots != nil && !withinRoots(target, opts.roots) {
+					printVerbose(fmt.Sprintf("Skipped: %s (symlink to %s, outside the scanned directories, --stay-within-root)\n", path, target))
+					return nil
+				}
+			}
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			if opts.strict {
+				return fmt.Errorf("failed to get file stats for %s: %w", path, err)
+			}
+
+			failures = append(failures, FileError{Path: path, Err: fmt.Errorf("failed to get file stats: %w", err)})
+			return nil
+		}
+
+		// Symlinks to directories were followed above. Anything else that
+		// leads to a directory, such as a Windows junction, is not
+		// descended into.
+		if info.IsDir() {
+			printVerbose(fmt.Sprintf("Skipped: %s (link to a directory, which is not followed)\n", path))
+			return nil
+		}
+
+		// Opening a named pipe blocks until something writes to it, so
+		// anything that is not a regular file is skipped by default.
+		if !info.Mode().IsRegular() && !opts.includeSpecial {
+			printVerbose(fmt.Sprintf("Skipped: %s (not a regular file, use --include-special to hash it)\n", path))
+			return nil
+		}
+
+		if info.Mode().IsRegular() && info.Size() == 0 && opts.emptyFiles == emptySkip {
+			printVerbose(fmt.Sprintf("Skipped: %s (empty file, use --include-zero-size-as-unique or --group-zero-size to keep it)\n", path))
+			return nil
+		}
+
+		fileInfo := HashedFileInfo{
+			Name:    info.Name(),
+			Size:    info.Size(),
+			Path:    path,
+			ModTime: info.ModTime(),
+			Mode:    info.Mode(),
+			FileID:  fileID(path, info),
+			Symlink: d.Type()&fs.ModeSymlink != 0 || len(linkParents) > 0,
+		}
+
+		if allocated, ok := allocatedSize(path, info); ok && allocated < info.Size() {
+			fileInfo.Sparse = true
+			fileInfo.Allocated = allocated
+		}
+
+		files = append(files, fileInfo)
+
+		return nil
+	}
+
+	// followDirLink walks the directory that the symlink at path resolves
+	// to as if it were at path. A link leading back to a directory the walk
+	// is already inside would be walked forever, so it is reported instead.
+	followDirLink = func(path string, target string) error {
+		if opts.roots != nil && !withinRoots(target, opts.roots) {
+			printVerbose(fmt.Sprintf("Skipped: %s (symlink to %s, outside the scanned directories, --stay-within-root)\n", path, target))
+			return nil
+		}
+
+		parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+		if err != nil {
+			parent = filepath.Dir(path)
+		}
+
+		parents := append(slices.Clip(linkParents), parent)
+		for _, dir := range parents {
+			if withinRoots(dir, []string{target}) {
+				printWarning(fmt.Sprintf("skipped symlink cycle at %s, which leads back to %s", path, target))
+				return nil
+			}
+		}
+
+		outer := linkParents
+		linkParents = parents
+		defer func() { linkParents = outer }()
+
+		// A trailing separator makes the walk start at the directory the
+		// link points to rather than at the link itself.
+		return filepath.WalkDir(path+string(filepath.Separator), visit)
+	}
+
+	err := filepath.WalkDir(folderPath, visit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return files, failures, nil
+}
+
+// hashFile hashes the file at path. onRead, when not nil, is called as the
+// file is read with the number of bytes read so far and the file size.
+func hashFile(ctx context.Context, path string, opts hashOptions, onRead func(done int64, total int64)) (string, error) {
+	acquireFile()
+	defer releaseFile()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	var source interface {
+		io.Reader
+		io.ReaderAt
+	} = file
+
+	if onRead != nil {
+		source = &progressReader{file: file, total: info.Size(), onRead: onRead}
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	var content io.Reader = source
+
+	// Types without a normalizer or decompressor fall back to hashing the
+	// raw bytes.
+	if decompress, ok := decompressors[ext]; ok && opts.decompress {
+		decompressed, err := decompress(source)
+		if err != nil {
+			return "", fmt.Errorf("failed to decompress content: %w", err)
+		}
+		defer decompressed.Close()
+
+		content = decompressed
+	} else if normalizer, ok := opts.normalizers[ext]; ok {
+		content, err = normalizer.normalize(source, info.Size())
+		if err != nil {
+			return "", fmt.Errorf("failed to normalize content: %w", err)
+		}
+
+		// Normalizers read a few bytes at the start or end of the file to
+		// find what to hash. Progress starts over for the pass over the
+		// content, so those bytes are not counted twice.
+		if reader, ok := source.(*progressReader); ok {
+			reader.done = 0
+		}
+	}
+
+	hash, err := newHasher(opts.algo)
+	if err != nil {
+		return "", err
+	}
+
+	// The whole chunks of a tree hash can be read in parallel when the raw
+	// bytes are what is hashed. The rest is read as usual after them.
+	if tree, ok := hash.(*treeHash); ok && content == source && opts.chunkWorkers > 1 {
+		covered, err := hashChunks(ctx, tree, file, info.Size(), opts.chunkWorkers, onRead)
+		if err != nil {
+			return "", err
+		}
+
+		_, err = file.Seek(covered, io.SeekStart)
+		if err != nil {
+			return "", err
+		}
+
+		if reader, ok := source.(*progressReader); ok {
+			reader.done = covered
+		}
+	}
+
+	if opts.checkpointDir != "" && content == source && info.Size() > checkpointInterval && checkpointable(hash) {
+		return hashCheckpointed(ctx, hash, file, source, info, path, opts)
+	}
+
+	return digestReader(ctx, hash, content, filepath.Base(path), info.Mode(), opts)
+}
+
+// hashCheckpointed hashes the raw content of file, read through source,
+// saving checkpoints into opts.checkpointDir. A checkpoint left by an
+// interrupted hash of the unchanged file is resumed from.
+func hashCheckpointed(ctx context.Context, hash hash.Hash, file *os.File, source io.Reader, info fs.FileInfo, path string, opts hashOptions) (string, error) {
+	checkpoints := newCheckpointer(opts.checkpointDir, path, info, opts.algo, hash)
+
+	if offset := checkpoints.resume(); offset > 0 {
+		_, err := file.Seek(offset, io.SeekStart)
+		if err != nil {
+			return "", err
+		}
+
+		if reader, ok := source.(*progressReader); ok {
+			reader.done = offset
+		}
+
+		printVerbose(fmt.Sprintf("Resuming %s from a checkpoint at %s\n", path, formatBytes(offset)))
+	}
+
+	digest, err := digestReader(ctx, hash, checkpoints.reader(source), filepath.Base(path), info.Mode(), opts)
+	if err != nil {
+		return "", err
+	}
+
+	checkpoints.finish()
+
+	return digest, nil
+}
+
+// hashReader hashes everything read from r. name and mode are only used
+// when opts asks for them to be part of the digest. ctx is checked between
+// buffer reads so hashing a large file can be canceled part way through.
+func hashReader(ctx context.Context, r io.Reader, name string, mode fs.FileMode, opts hashOptions) (string, error) {
+	hash, err := newHasher(opts.algo)
+	if err != nil {
+		return "", err
+	}
+
+	return digestReader(ctx, hash, r, name, mode, opts)
 }
 
-var rootCmd = &cobra.Command{
-	Use:   "dupe-d [directory]",
-	Short: "dupe-d is a tool to identify file duplicates",
-	Long: `dupe-d is a tool to identify file duplicates by generating sha-256 hash.
-	To scan the current directory, use: dupe-d .`,
-	Example: `  dupe-d 
-  dupe-d /path/to/directory
-  dupe-d --ext jpg --ext png /path/to/directory
-  dupe-d --ext=jpg,png,pdf`,
-	Args: cobra.MaximumNArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
+// digestReader is hashReader with the hash to feed given.
+func digestReader(ctx context.Context, hash hash.Hash, r io.Reader, name string, mode fs.FileMode, opts hashOptions) (string, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
 
-		folderPath, err := getFolderPath(args)
-		if err != nil {
-			return err
-		}
+	_, err := io.CopyBuffer(hash, &contextReader{ctx: ctx, r: r}, *buf)
+	if err != nil {
+		return "", err
+	}
 
-		formattedExtensions := formatExtensions(extensions)
+	// Attributes are folded in after the content, each behind a label so
+	// that a name can never be mistaken for a mode and vice versa.
+	if opts.includeName {
+		hash.Write([]byte("\x00name\x00"))
+		hash.Write([]byte(name))
+	}
 
-		hashedFilesInfo, err := processFiles(folderPath, formattedExtensions)
-		if err != nil {
-			return err
-		}
+	if opts.includeMode {
+		hash.Write([]byte("\x00mode\x00"))
+		hash.Write(binary.BigEndian.AppendUint32(nil, uint32(mode)))
+	}
 
-		err = writeToCsv(hashedFilesInfo)
-		if err != nil {
-			return err
-		}
+	digest := fmt.Sprintf("%x", hash.Sum(nil))
+	if opts.length > 0 && opts.length < len(digest) {
+		digest = digest[:opts.length]
+	}
 
-		return nil
+	return digest, nil
+}
+
+// copyBuffers holds the buffers hashReader copies through. Allocating a
+// fresh 1 MB buffer for every file left most of a large scan's memory use
+// to garbage waiting to be collected.
+var copyBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 1024*1024)
+		return &buf
 	},
 }
 
-func init() {
-	rootCmd.Flags().StringSliceVarP(&extensions, "ext", "e", []string{}, "File extensions to process (can be specified multiple times or comma-separated)")
+// contextReader fails reads once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
 }
 
-func main() {
-	if err := rootCmd.Execute(); err != nil {
-		printToStdErr(err)
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
 	}
+
+	return c.r.Read(p)
 }
 
-func getFolderPath(args []string) (string, error) {
-	if len(args) > 0 {
-		return validateDirectory(args[0])
+// hashesMatch compares two hex digests, either of which may have been
+// truncated with --hash-length: the shorter one must be a prefix of the
+// longer one.
+func hashesMatch(a string, b string) bool {
+	if a == "" || b == "" {
+		return a == b
 	}
 
-	currentDir, err := os.Getwd()
-	if err != nil {
-		return "", err
+	if len(a) > len(b) {
+		a, b = b, a
 	}
 
-	return currentDir, nil
+	return strings.HasPrefix(b, a)
 }
 
-func validateDirectory(path string) (string, error) {
-	info, err := os.Stat(path)
+// getOutputDir returns the directory timestamped output files are written
+// to: the directory of the first --output, --output-dir, or the current
+// directory.
+func getOutputDir() string {
+	if len(outputFiles) > 0 {
+		return filepath.Dir(outputFiles[0])
+	}
+
+	if outputDir != "" {
+		return outputDir
+	}
+
+	return "."
+}
+
+// getOutputDirs returns every directory output files may be written to.
+func getOutputDirs() []string {
+	dirs := []string{getOutputDir()}
+
+	for _, filename := range outputFiles {
+		dir := filepath.Dir(filename)
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+func getResultsFilenames() []string {
+	if len(outputFiles) > 0 {
+		return outputFiles
+	}
+
+	return []string{timestampedFilename("hash_results")}
+}
+
+func timestampedFilename(prefix string) string {
+	ext := ".csv"
+	if gzipOutput {
+		ext += gzipExtension
+	}
+
+	if deterministic {
+		return filepath.Join(getOutputDir(), prefix+ext)
+	}
+
+	// A time from SOURCE_DATE_EPOCH is in UTC even without --utc-timestamps,
+	// so the Z follows the time zone rather than the flag.
+	t := outputTime()
+	timestamp := t.Format("20060102_150405") // Format: YYYYMMDD_HHMMSS
+	if t.Location() == time.UTC {
+		timestamp += "Z"
+	}
+
+	return filepath.Join(getOutputDir(), fmt.Sprintf("%s_%s%s", prefix, timestamp, ext))
+}
+
+// fixedOutputTime returns the time to record in output instead of the
+// current time, taken from SOURCE_DATE_EPOCH (see
+// https://reproducible-builds.org/specs/source-date-epoch/) or, with
+// --deterministic, the Unix epoch. The zero time means no fixed time.
+func fixedOutputTime() (time.Time, error) {
+	if epoch, ok := os.LookupEnv("SOURCE_DATE_EPOCH"); ok {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %w", epoch, err)
+		}
+
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+
+	if deterministic {
+		return time.Unix(0, 0).UTC(), nil
+	}
+
+	return time.Time{}, nil
+}
+
+// outputTime is the time recorded in output filenames and the JSON
+// envelope, in local time unless --utc-timestamps is given.
+func outputTime() time.Time {
+	if fixedTime.IsZero() && utcTimestamps {
+		return time.Now().UTC()
+	}
+	if fixedTime.IsZero() {
+		return time.Now()
+	}
+
+	return fixedTime
+}
+
+// checkWritable verifies that files can be created in dir by creating and
+// removing a temporary file. It runs before the scan so a bad output
+// location is reported before any time is spent hashing.
+func checkWritable(dir string) error {
+	info, err := os.Stat(dir)
 	if err != nil {
-		return "", fmt.Errorf("directory not accessible: %w", err)
+		return fmt.Errorf("output directory not accessible: %w", err)
 	}
 
 	if !info.IsDir() {
-		return "", fmt.Errorf("path is not a directory: %s", path)
+		return fmt.Errorf("output location is not a directory: %s", dir)
 	}
 
-	return path, nil
+	file, err := os.CreateTemp(dir, ".dupe-d-write-check-*")
+	if err != nil {
+		return fmt.Errorf("output directory %s is not writable, use --output or --output-dir to choose another location: %w", dir, err)
+	}
+
+	file.Close()
+	os.Remove(file.Name())
+
+	return nil
 }
 
-func formatExtensions(rawExts []string) []string {
-	var formattedExts []string
+// csvHeader returns the header of the results CSV for the --columns in
+// effect.
+func csvHeader() []string {
+	names := selectedColumns()
 
-	for _, ext := range rawExts {
-		splitExts := strings.Split(ext, ",")
+	header := make([]string, len(names))
+	for i, name := range names {
+		header[i] = csvColumns[name].header
+	}
 
-		for _, splitExt := range splitExts {
-			splitExt = strings.TrimSpace(splitExt)
+	return header
+}
 
-			if splitExt == "" {
-				continue
-			}
+func csvRecord(hashedFileInfo HashedFileInfo) []string {
+	names := selectedColumns()
 
-			if !strings.HasPrefix(splitExt, ".") {
-				splitExt = "." + splitExt
-			}
+	record := make([]string, len(names))
+	for i, name := range names {
+		record[i] = csvColumns[name].value(hashedFileInfo)
+	}
 
-			formattedExts = append(formattedExts, splitExt)
-		}
+	return record
+}
+
+// allocatedDescription returns the space a sparse file occupies on disk, or
+// "" for files that occupy their full size.
+func allocatedDescription(file HashedFileInfo) string {
+	if !file.Sparse {
+		return ""
 	}
 
-	return formattedExts
+	return strconv.FormatInt(file.Allocated, 10)
 }
 
-func processFiles(folderPath string, exts []string) ([]HashedFileInfo, error) {
-	printToStdOut(fmt.Sprintf("Scanning folder: %s\n", folderPath))
-	if len(exts) > 0 {
-		printToStdOut(fmt.Sprintf("Filtering by extensions: %s\n", strings.Join(exts, ", ")))
-	} else {
-		printToStdOut("Processing all file types\n")
+// hashAlgorithm returns the algorithm that produced file's hash, or "" for
+// entries without a hash such as recorded symlinks.
+func hashAlgorithm(file HashedFileInfo) string {
+	switch {
+	case file.Hash == "":
+		return ""
+	case file.Algorithm != "":
+		return file.Algorithm
 	}
 
-	var files []HashedFileInfo
+	return hashOpts.algo
+}
+
+// printPreview writes the header and the first n rows of the results CSV to
+// stdout instead of creating the output file.
+func printPreview(hashedFilesInfo []HashedFileInfo, n int) error {
+	rows := min(n, len(hashedFilesInfo))
+
+	writer := csv.NewWriter(os.Stdout)
 
-	err := filepath.WalkDir(folderPath, func(path string, d fs.DirEntry, err error) error {
+	err := writer.Write(csvHeader())
+	if err != nil {
+		return fmt.Errorf("failed to write preview: %w", err)
+	}
 
+	for _, hashedFileInfo := range hashedFilesInfo[:rows] {
+		err = writer.Write(csvRecord(hashedFileInfo))
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to write preview: %w", err)
 		}
+	}
 
-		if d.IsDir() {
-			return nil
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write preview: %w", err)
+	}
+
+	printToStdOut(fmt.Sprintf("Preview: showing %d of %d rows, no output file written\n", rows, len(hashedFilesInfo)))
+
+	return nil
+}
+
+// utf8BOM marks a file as UTF-8 for spreadsheet applications that would
+// otherwise assume a legacy code page.
+const utf8BOM = "\ufeff"
+
+// newCsvWriter returns a CSV writer for w, starting the output with a UTF-8
+// byte order mark when --csv-bom is set.
+func newCsvWriter(w io.Writer) (*csv.Writer, error) {
+	if csvBOM {
+		_, err := io.WriteString(w, utf8BOM)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		if matchesExtension(path, exts) {
-			printToStdOut(fmt.Sprintf("Processing: %s\n", path))
+	return csv.NewWriter(w), nil
+}
 
-			hash, err := hashFile(path)
-			if err != nil {
-				return fmt.Errorf("failed to hash file %s: %w", path, err)
-			}
+func writeToCsv(hashedFilesInfo []HashedFileInfo, outputFilename string) error {
+	if groupSep {
+		hashedFilesInfo = groupedOrder(hashedFilesInfo)
+	}
 
-			info, err := os.Stat(path)
-			if err != nil {
-				return fmt.Errorf("failed to get file stats for %s: %w", path, err)
-			}
+	digits := shardPrefixLen(shards)
+	if digits == 0 {
+		return writeCsvParts(hashedFilesInfo, outputFilename)
+	}
 
-			fileInfo := HashedFileInfo{
-				Name: info.Name(),
-				Size: info.Size(),
-				Hash: hash,
-				Path: path,
-			}
+	// Every shard is written, even an empty one, so that a sharded run
+	// always leaves the same set of files.
+	byShard := shardFiles(hashedFilesInfo, digits)
+	for i := 0; i < shards; i++ {
+		prefix := fmt.Sprintf("%0*x", digits, i)
 
-			files = append(files, fileInfo)
+		err := writeCsvParts(byShard[prefix], shardFilename(outputFilename, prefix))
+		if err != nil {
+			return err
 		}
+	}
 
-		return nil
+	return nil
+}
+
+// groupedOrder returns files with the members of each duplicate group next
+// to each other, in group order, followed by the files in no group. Files
+// keep their scan order within a group.
+func groupedOrder(files []HashedFileInfo) []HashedFileInfo {
+	sorted := make([]HashedFileInfo, len(files))
+	copy(sorted, files)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].GroupID, sorted[j].GroupID
+		if a == 0 || b == 0 {
+			return b == 0 && a != 0
+		}
+		return a < b
 	})
 
-	if err != nil {
-		return nil, err
+	return sorted
+}
+
+// writeCsvParts writes hashedFilesInfo to outputFilename, split into
+// numbered parts with --max-output-size.
+func writeCsvParts(hashedFilesInfo []HashedFileInfo, outputFilename string) error {
+	// With --max-output-size the rows are spread over numbered parts;
+	// otherwise the first part takes them all.
+	part := 1
+	for written := 0; ; part++ {
+		n, err := writeCsvPart(hashedFilesInfo[written:], rotatedFilename(outputFilename, part))
+		if err != nil {
+			return err
+		}
+
+		written += n
+		if written == len(hashedFilesInfo) {
+			break
+		}
 	}
 
-	return files, nil
+	if _, err := os.Stat(rotatedFilename(outputFilename, part+1)); err == nil {
+		printWarning(fmt.Sprintf("%s is left over from an earlier run and is not part of these results", rotatedFilename(outputFilename, part+1)))
+	}
+
+	return nil
 }
 
-func hashFile(path string) (string, error) {
-	file, err := os.Open(path)
+// writeCsvPart writes a header and as many of files as fit within
+// --max-output-size to filename, but at least one, and returns how many it
+// wrote. With --group-separator a blank row goes wherever the group changes.
+func writeCsvPart(files []HashedFileInfo, filename string) (int, error) {
+	output, err := createOutput(filename)
 	if err != nil {
-		return "", err
+		return 0, fmt.Errorf("failed to create CSV file: %w", err)
 	}
+	defer output.Close()
 
-	defer file.Close()
+	file := bufio.NewWriter(output)
 
-	hash := sha256.New()
-	buf := make([]byte, 1024*1024)
+	// Rows are encoded into row first, so the size of the part is known
+	// before a row that would not fit is written.
+	var row bytes.Buffer
+	rowWriter := csv.NewWriter(&row)
 
-	_, err = io.CopyBuffer(hash, file, buf)
+	encode := func(record []string) ([]byte, error) {
+		row.Reset()
+		rowWriter.Write(record)
+		rowWriter.Flush()
+		return row.Bytes(), rowWriter.Error()
+	}
+
+	var size int64
+	if csvBOM {
+		_, err = io.WriteString(file, utf8BOM)
+		if err != nil {
+			return 0, fmt.Errorf("failed to write CSV: %w", err)
+		}
+		size += int64(len(utf8BOM))
+	}
+
+	header, err := encode(csvHeader())
+	if err == nil {
+		_, err = file.Write(header)
+	}
 	if err != nil {
-		return "", err
+		return 0, fmt.Errorf("failed to write header to CSV: %w", err)
+	}
+	size += int64(len(header))
+
+	var n int
+	for _, hashedFileInfo := range files {
+		record, err := encode(csvRecord(hashedFileInfo))
+		if err != nil {
+			return 0, fmt.Errorf("failed to write content to CSV: %w", err)
+		}
+
+		// A blank row is an empty line, which encoding/csv skips when
+		// reading the file back.
+		if groupSep && n > 0 && hashedFileInfo.GroupID != files[n-1].GroupID {
+			record = append([]byte("\n"), record...)
+		}
+
+		if maxOutputSize > 0 && n > 0 && size+int64(len(record)) > int64(maxOutputSize) {
+			break
+		}
+
+		_, err = file.Write(record)
+		if err != nil {
+			return 0, fmt.Errorf("failed to write content to CSV: %w", err)
+		}
+
+		size += int64(len(record))
+		n++
+	}
+
+	err = file.Flush()
+	if err != nil {
+		return 0, fmt.Errorf("failed to write content to CSV: %w", err)
+	}
+
+	// Closing finishes a compressed stream, so its error matters.
+	err = output.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to write CSV file: %w", err)
+	}
+
+	absPath, err := filepath.Abs(filename)
+	if err != nil {
+		absPath = filename
+	}
+
+	printToStdOut(fmt.Sprintf("Output written to: %s\n", absPath))
+
+	return n, nil
+}
+
+// rotatedFilename returns the name of the given part of a CSV output split
+// by --max-output-size. The first part keeps the name; later parts have
+// their number before the extension, so results.csv.gz continues in
+// results.2.csv.gz.
+func rotatedFilename(filename string, part int) string {
+	if part == 1 {
+		return filename
+	}
+
+	base := filename
+	suffix := ""
+	if strings.HasSuffix(strings.ToLower(base), gzipExtension) {
+		base, suffix = base[:len(base)-len(gzipExtension)], base[len(base)-len(gzipExtension):]
+	}
+
+	ext := filepath.Ext(base)
+
+	return fmt.Sprintf("%s.%d%s%s", strings.TrimSuffix(base, ext), part, ext, suffix)
+}
+
+// findNearDuplicateCandidates clusters files whose sizes are within tolerance
+// percent of the smallest file in the cluster. Clusters made up entirely of
+// identical hashes are left out, since those are already exact duplicates.
+func findNearDuplicateCandidates(files []HashedFileInfo, tolerance float64) [][]HashedFileInfo {
+	sorted := make([]HashedFileInfo, len(files))
+	copy(sorted, files)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Size < sorted[j].Size
+	})
+
+	var candidates [][]HashedFileInfo
+	var cluster []HashedFileInfo
+
+	flush := func() {
+		if len(cluster) > 1 && hasDistinctHashes(cluster) {
+			candidates = append(candidates, cluster)
+		}
+		cluster = nil
+	}
+
+	for _, file := range sorted {
+		if len(cluster) > 0 {
+			limit := float64(cluster[0].Size) * (1 + tolerance/100)
+			if float64(file.Size) > limit {
+				flush()
+			}
+		}
+		cluster = append(cluster, file)
+	}
+	flush()
+
+	return candidates
+}
+
+func hasDistinctHashes(files []HashedFileInfo) bool {
+	for _, file := range files[1:] {
+		if file.Hash != files[0].Hash {
+			return true
+		}
 	}
 
-	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+	return false
 }
 
-func writeToCsv(hashedFilesInfo []HashedFileInfo) error {
+func writeCandidatesToCsv(candidates [][]HashedFileInfo) error {
+	if len(candidates) == 0 {
+		printToStdOut("No near-duplicate candidates found\n")
+		return nil
+	}
 
-	timestamp := time.Now().Format("20060102_150405") // Format: YYYYMMDD_HHMMSS
-	outputFilename := fmt.Sprintf("hash_results_%s.csv", timestamp)
+	outputFilename := timestampedFilename("near_duplicate_candidates")
 
-	file, err := os.Create(outputFilename)
+	file, err := createOutput(outputFilename)
 	if err != nil {
-		return fmt.Errorf("failed to create CSV file: %w", err)
+		return fmt.Errorf("failed to create candidates CSV file: %w", err)
 	}
 	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	writer, err := newCsvWriter(file)
+	if err != nil {
+		return fmt.Errorf("failed to write candidates CSV: %w", err)
+	}
 
-	err = writer.Write([]string{"Name", "Path", "Size (MB)", "Hash"})
+	err = writer.Write([]string{"Candidate Group", "Name", "Path", "Size (MB)", "Hash"})
 	if err != nil {
-		return fmt.Errorf("failed to write header to CSV: %w", err)
+		return fmt.Errorf("failed to write header to candidates CSV: %w", err)
 	}
 
-	for _, hashedFileInfo := range hashedFilesInfo {
+	for i, group := range candidates {
+		groupLabel := fmt.Sprintf("candidate-%d", i+1)
+
+		if groupSep && i > 0 {
+			err = writer.Write([]string{})
+			if err != nil {
+				return fmt.Errorf("failed to write content to candidates CSV: %w", err)
+			}
+		}
 
-		sizeInMB := float64(hashedFileInfo.Size) / 1048576.0
+		for _, hashedFileInfo := range group {
+			sizeInMB := float64(hashedFileInfo.Size) / 1048576.0
 
-		err = writer.Write([]string{
-			hashedFileInfo.Name,
-			hashedFileInfo.Path,
-			fmt.Sprintf("%.2f", sizeInMB),
-			hashedFileInfo.Hash,
-		})
-		if err != nil {
-			return fmt.Errorf("failed to write content to CSV: %w", err)
+			err = writer.Write([]string{
+				groupLabel,
+				hashedFileInfo.Name,
+				hashedFileInfo.Path,
+				fmt.Sprintf("%.2f", sizeInMB),
+				hashedFileInfo.Hash,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to write content to candidates CSV: %w", err)
+			}
 		}
 	}
 
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write content to candidates CSV: %w", err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write candidates CSV file: %w", err)
+	}
+
 	absPath, err := filepath.Abs(outputFilename)
 	if err != nil {
 		absPath = outputFilename
 	}
 
-	printToStdOut(fmt.Sprintf("Output written to: %s\n", absPath))
+	printToStdOut(fmt.Sprintf("Near-duplicate candidates (similar size, not confirmed matches) written to: %s\n", absPath))
 
 	return nil
 }
 
+// capResults keeps the first --max-results groups and warns when any had
+// to be dropped. what names the groups in the warning.
+func capResults(groups [][]HashedFileInfo, what string) [][]HashedFileInfo {
+	if maxResults == 0 || len(groups) <= maxResults {
+		return groups
+	}
+
+	printWarning(fmt.Sprintf("showing only the first %d of %d %s (--max-results); narrow the scan with --ext, --skip-files-matching or --min-savings to see the rest", maxResults, len(groups), what))
+
+	return groups[:maxResults]
+}
+
+// isSkipped reports whether path is excluded by --skip-files-matching.
+func isSkipped(path string, opts scanOptions) bool {
+	return opts.skipPattern != nil && opts.skipPattern.MatchString(path)
+}
+
 func matchesExtension(path string, exts []string) bool {
 	if len(exts) == 0 {
 		return true
@@ -249,10 +2444,59 @@ func matchesExtension(path string, exts []string) bool {
 	return false
 }
 
+// printCount writes the duplicate count straight to stdout, bypassing
+// --quiet, since it is the only output in count mode.
+func printCount(groups [][]HashedFileInfo, mode string) {
+	count := len(groups)
+
+	if mode == "files" {
+		count = 0
+		for _, group := range groups {
+			count += len(group) - 1
+		}
+	}
+
+	fmt.Fprintln(os.Stdout, count)
+}
+
+func printWarning(s string) {
+	fmt.Fprintf(os.Stderr, "Warning: %s\n", s)
+}
+
 func printToStdErr(err error) {
 	fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
 }
 
+// printProgress prints a progress update unless --no-progress is set.
+func printProgress(s string) {
+	if !noProgress {
+		printToStdOut(s)
+	}
+}
+
+// printVerbose prints s only when --verbose is set.
+func printVerbose(s string) {
+	if verbose {
+		printToStdOut(s)
+	}
+}
+
 func printToStdOut(s string) {
+	if quiet {
+		return
+	}
+
 	fmt.Fprint(os.Stdout, s)
 }
+
+// withinRoots reports whether path lies inside one of roots.
+func withinRoots(path string, roots []string) bool {
+	for _, root := range roots {
+		rel, err := filepath.Rel(root, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testScanOptions returns the scanOptions of a scan run without flags.
+func testScanOptions() scanOptions {
+	return scanOptions{
+		hash:        hashOptions{algo: defaultAlgorithm, chunkWorkers: 1},
+		workers:     1,
+		order:       orderWalk,
+		emptyFiles:  emptySkip,
+		symlinkMode: symlinkSkip,
+	}
+}
+
+// quietOutput turns on --quiet for the rest of the test.
+func quietOutput(t *testing.T) {
+	t.Helper()
+
+	old := quiet
+	quiet = true
+	t.Cleanup(func() { quiet = old })
+}
+
+// captureStdout returns what fn printed to standard output.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	return captureOutput(t, &os.Stdout, fn)
+}
+
+// captureStderr returns what fn printed to standard error.
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+
+	return captureOutput(t, &os.Stderr, fn)
+}
+
+// captureOutput returns what fn wrote to *file.
+func captureOutput(t *testing.T, file **os.File, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+
+	original := *file
+	*file = w
+	defer func() { *file = original }()
+
+	fn()
+
+	w.Close()
+	return <-output
+}
+
+// verboseOutput turns on --verbose for the rest of the test.
+func verboseOutput(t *testing.T) {
+	t.Helper()
+
+	old := verbose
+	verbose = true
+	t.Cleanup(func() { verbose = old })
+}
+
+// writeFiles creates the files in contents, by path relative to dir, along
+// with the directories they are in.
+func writeFiles(t *testing.T, dir string, contents map[string]string) {
+	t.Helper()
+
+	for name, content := range contents {
+		path := filepath.Join(dir, name)
+
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(path, []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// endlessReader returns zeros forever, like a file too large to finish
+// hashing in a test.
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestHashReaderStopsWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := hashReader(ctx, endlessReader{}, "", 0, hashOptions{algo: defaultAlgorithm})
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("hashReader returned %v, want context.DeadlineExceeded", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("hashReader took %s to notice the cancellation", elapsed)
+	}
+}
+
+func TestEmptyFileDigestMatchesHashedEmptyFile(t *testing.T) {
+	quietOutput(t)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"empty": ""})
+	path := filepath.Join(dir, "empty")
+
+	for _, name := range algorithmNames() {
+		for _, algo := range []string{name, name + treeSuffix} {
+			t.Run(algo, func(t *testing.T) {
+				opts := testScanOptions()
+				opts.hash.algo = algo
+				opts.emptyFiles = emptyUnique
+
+				// The scan gives the empty file the precomputed digest
+				// without opening it.
+				files, err := processFiles(context.Background(), []string{dir}, opts)
+				if err != nil {
+					t.Fatalf("scan failed: %v", err)
+				}
+				if len(files) != 1 {
+					t.Fatalf("scan returned %d files, want 1", len(files))
+				}
+
+				want, err := hashFile(context.Background(), path, opts.hash, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if files[0].Hash != want {
+					t.Errorf("empty file got digest %s, hashing it gives %s", files[0].Hash, want)
+				}
+			})
+		}
+	}
+}
+
+func TestSkipFiltersTakePrecedenceOverExt(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"keep.jpg":         "kept",
+		"cache/skip.jpg":   "matches --skip-files-matching",
+		".git/objects.jpg": "inside a version-control directory",
+		"other.png":        "not selected by --ext",
+	})
+
+	opts := testScanOptions()
+	opts.exts = []string{".jpg"}
+	opts.skipPattern = regexp.MustCompile(`skip\.jpg$`)
+	opts.skipVCS = true
+
+	files, _, err := collectFiles(context.Background(), dir, opts)
+	if err != nil {
+		t.Fatalf("walk failed: %v", err)
+	}
+
+	if len(files) != 1 || files[0].Path != filepath.Join(dir, "keep.jpg") {
+		t.Errorf("walk found %v, want only keep.jpg", files)
+	}
+}
+
+func TestSourceDateEpochFilenameIsMarkedUTC(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+
+	epoch, err := fixedOutputTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := fixedTime
+	fixedTime = epoch
+	t.Cleanup(func() { fixedTime = old })
+
+	name := filepath.Base(timestampedFilename("hash_results"))
+	if want := "hash_results_20231114_221320Z.csv"; name != want {
+		t.Errorf("timestamped filename is %s, want %s", name, want)
+	}
+}
+
+func TestGroupSeparatorWritesResultsByGroup(t *testing.T) {
+	quietOutput(t)
+
+	old := groupSep
+	groupSep = true
+	t.Cleanup(func() { groupSep = old })
+
+	files := []HashedFileInfo{
+		{Name: "a1", Path: "a1", Hash: "a", GroupID: 1},
+		{Name: "u", Path: "u", Hash: "u"},
+		{Name: "b1", Path: "b1", Hash: "b", GroupID: 2},
+		{Name: "a2", Path: "a2", Hash: "a", GroupID: 1},
+		{Name: "b2", Path: "b2", Hash: "b", GroupID: 2},
+	}
+
+	filename := filepath.Join(t.TempDir(), "results.csv")
+	err := writeToCsv(files, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")[1:] {
+		name, _, _ := strings.Cut(line, ",")
+		names = append(names, name)
+	}
+
+	want := []string{"a1", "a2", "", "b1", "b2", "", "u"}
+	if !slices.Equal(names, want) {
+		t.Errorf("rows are %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,308 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSymlinkCycleIsReportedAndNotFollowed(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a/one.txt": "one", "b/two.txt": "two", "c/three.txt": "three"})
+
+	// a/to-b and b/to-a lead back into each other forever if followed,
+	// while links/to-c is an ordinary link to a directory.
+	for link, target := range map[string]string{"a/to-b": "../b", "b/to-a": "../a", "links/to-c": "../c"} {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(dir, link)), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.Symlink(target, filepath.Join(dir, link))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := testScanOptions()
+	opts.symlinkMode = symlinkFollow
+
+	var files []HashedFileInfo
+	var err error
+	done := make(chan struct{})
+
+	warnings := captureStderr(t, func() {
+		go func() {
+			defer close(done)
+			files, _, err = collectFiles(context.Background(), dir, opts)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("walk through a symlink cycle did not finish")
+		}
+	})
+
+	if err != nil {
+		t.Fatalf("walk failed: %v", err)
+	}
+
+	var paths []string
+	for _, file := range files {
+		rel, _ := filepath.Rel(dir, file.Path)
+		paths = append(paths, filepath.ToSlash(rel))
+
+		if linked := strings.Contains(rel, "to-"); file.Symlink != linked {
+			t.Errorf("%s has Symlink %t, want %t", rel, file.Symlink, linked)
+		}
+	}
+	slices.Sort(paths)
+
+	// Each directory is scanned once through each link that does not lead
+	// back into it.
+	want := []string{"a/one.txt", "a/to-b/two.txt", "b/to-a/one.txt", "b/two.txt", "c/three.txt", "links/to-c/three.txt"}
+	if !slices.Equal(paths, want) {
+		t.Errorf("walk found %v, want %v", paths, want)
+	}
+
+	for _, link := range []string{"a/to-b/to-a", "b/to-a/to-b"} {
+		report := "skipped symlink cycle at " + filepath.Join(dir, link) + ","
+		if !strings.Contains(warnings, report) {
+			t.Errorf("warnings do not report the cycle at %s; want %q in:\n%s", link, report, warnings)
+		}
+	}
+
+	if n := strings.Count(warnings, "skipped symlink cycle"); n != 2 {
+		t.Errorf("%d cycles reported, want 2:\n%s", n, warnings)
+	}
+}
+
+func TestFileRemovedBeforeHashingIsSkipped(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict=%t", strict), func(t *testing.T) {
+			verboseOutput(t)
+
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"b.txt": "gone by the time it is hashed"})
+
+			// Hashing the FIFO, which the walk finds first, blocks until
+			// something opens it for writing. Opening it therefore waits
+			// for the walk to end and the hashing to start, and the file
+			// is removed then.
+			fifo := filepath.Join(dir, "a.fifo")
+			err := syscall.Mkfifo(fifo, 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			removed := make(chan error, 1)
+			go func() {
+				writer, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+				if err != nil {
+					removed <- err
+					return
+				}
+
+				removed <- os.Remove(filepath.Join(dir, "b.txt"))
+				writer.Close()
+			}()
+
+			opts := testScanOptions()
+			opts.includeSpecial = true
+			opts.strict = strict
+
+			var files []HashedFileInfo
+			output := captureStdout(t, func() {
+				files, err = processFiles(context.Background(), []string{dir}, opts)
+			})
+
+			if removeErr := <-removed; removeErr != nil {
+				t.Fatal(removeErr)
+			}
+
+			if err != nil {
+				t.Fatalf("scan failed: %v", err)
+			}
+
+			if len(files) != 1 || files[0].Path != fifo {
+				t.Errorf("scan returned %v, want only %s", files, fifo)
+			}
+
+			want := "Skipped: " + filepath.Join(dir, "b.txt") + " (disappeared during scan)"
+			if !strings.Contains(output, want) {
+				t.Errorf("output does not report the removed file; want %q in:\n%s", want, output)
+			}
+		})
+	}
+}
+
+func TestSymlinkOutsideRootIsSkipped(t *testing.T) {
+	verboseOutput(t)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"inside.txt": "inside"})
+
+	// The target lies directly in the temporary directory, such as /tmp,
+	// outside the scanned directory.
+	outside, err := os.CreateTemp("", "dupe-d-outside-*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside.WriteString("outside")
+	outside.Close()
+	t.Cleanup(func() { os.Remove(outside.Name()) })
+
+	link := filepath.Join(dir, "outside.txt")
+	err = os.Symlink(outside.Name(), link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := filepath.EvalSymlinks(outside.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stayWithinRoot := range []bool{true, false} {
+		t.Run(fmt.Sprintf("stay-within-root=%t", stayWithinRoot), func(t *testing.T) {
+			opts := testScanOptions()
+			opts.symlinkMode = symlinkFollow
+			opts.stayWithinRoot = stayWithinRoot
+
+			var files []HashedFileInfo
+			output := captureStdout(t, func() {
+				files, _, err = collectRoots(context.Background(), []string{dir}, opts)
+			})
+			if err != nil {
+				t.Fatalf("walk failed: %v", err)
+			}
+
+			var paths []string
+			for _, file := range files {
+				paths = append(paths, file.Path)
+			}
+
+			want := []string{filepath.Join(dir, "inside.txt")}
+			if !stayWithinRoot {
+				want = []string{filepath.Join(dir, "inside.txt"), link}
+			}
+			if !slices.Equal(paths, want) {
+				t.Errorf("walk found %v, want %v", paths, want)
+			}
+
+			report := "Skipped: " + link + " (symlink to " + target + ", outside the scanned directories, --stay-within-root)"
+			reported := strings.Contains(output, report)
+			if stayWithinRoot && !reported {
+				t.Errorf("output does not report the skipped symlink; want %q in:\n%s", report, output)
+			}
+			if !stayWithinRoot && reported {
+				t.Errorf("symlink reported as skipped although it was followed:\n%s", output)
+			}
+		})
+	}
+}
+
+func TestSafeModeSkipsCopiesOfSymlinkedKeeper(t *testing.T) {
+	for _, safe := range []bool{true, false} {
+		t.Run(fmt.Sprintf("safe-mode=%t", safe), func(t *testing.T) {
+			quietOutput(t)
+
+			old := safeMode
+			safeMode = safe
+			t.Cleanup(func() { safeMode = old })
+
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"a.txt": "same", "b.txt": "same"})
+
+			results := scanToCsv(t, dir)
+
+			// After the scan, the kept a.txt is replaced by a symlink to a
+			// file outside the group. Its content still matches, but
+			// deleting b.txt would leave the only copy outside the scan.
+			outside := t.TempDir()
+			writeFiles(t, outside, map[string]string{"outside.txt": "same"})
+
+			err := os.Remove(filepath.Join(dir, "a.txt"))
+			if err == nil {
+				err = os.Symlink(filepath.Join(outside, "outside.txt"), filepath.Join(dir, "a.txt"))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			errors := captureStderr(t, func() {
+				err = applyCsv(t, results, actionDelete)
+			})
+
+			_, statErr := os.Stat(filepath.Join(dir, "b.txt"))
+			deleted := os.IsNotExist(statErr)
+
+			if safe {
+				if err == nil || deleted {
+					t.Errorf("safe mode deleted b.txt next to a symlinked keeper (apply returned %v)", err)
+				}
+				if !strings.Contains(errors, "is not a regular file") {
+					t.Errorf("safe mode did not report the symlinked keeper:\n%s", errors)
+				}
+			} else if err != nil || !deleted {
+				t.Errorf("without safe mode b.txt was not deleted (apply returned %v)", err)
+			}
+		})
+	}
+}
+
+func TestConcurrentCacheSavesKeepAllEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	opts := hashOptions{algo: defaultAlgorithm}
+
+	// Every scan starts from the same empty cache and hashes a file of its
+	// own, as scans of different directories sharing --cache-file would.
+	caches := make([]*hashCache, 16)
+	files := make([]HashedFileInfo, len(caches))
+	for i := range caches {
+		cache, err := loadHashCache(path, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		caches[i] = cache
+		files[i] = HashedFileInfo{
+			Path:    filepath.Join("/scan", fmt.Sprint(i), "file.txt"),
+			Size:    int64(i),
+			ModTime: time.Unix(int64(i), 0),
+			Hash:    fmt.Sprintf("hash-%d", i),
+		}
+		cache.store(files[i])
+	}
+
+	errs := make(chan error, len(caches))
+	for _, cache := range caches {
+		go func() { errs <- cache.save() }()
+	}
+	for range caches {
+		if err := <-errs; err != nil {
+			t.Fatalf("save failed: %v", err)
+		}
+	}
+
+	cache, err := loadHashCache(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		entry, ok := cache.lookup(file)
+		if !ok || entry.Hash != file.Hash {
+			t.Errorf("cache has %v for %s after all scans saved, want hash %s", entry, file.Path, file.Hash)
+		}
+	}
+}
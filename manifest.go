@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var checkFilesExist bool
+
+var manifestCmd = &cobra.Command{
+	Use:   "manifest",
+	Short: "Check results files before using them",
+}
+
+var manifestValidateCmd = &cobra.Command{
+	Use:   "validate <results.csv>",
+	Short: "Check a results file for malformed or inconsistent rows",
+	Long: `validate reads a results CSV, as written by a scan and read by --verify, merge
+and apply, and reports every problem it finds instead of stopping at the
+first one: rows that cannot be parsed, rows without a path or hash, hashes
+that are not hex or whose length or algorithm differs from the rest of the
+file, and paths listed more than once. With --check-files it also reports
+listed files that no longer exist. It exits with an error if any problem
+was found.`,
+	Example: `  dupe-d manifest validate results.csv
+  dupe-d manifest validate --check-files results.csv.gz`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		path, err := expandHome(args[0])
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open results file: %w", err)
+		}
+		defer file.Close()
+
+		rows, problems, err := validateManifest(file, path)
+		if err != nil {
+			return err
+		}
+
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stdout, problem)
+		}
+
+		if len(problems) > 0 {
+			return fmt.Errorf("found %d problems in the %d rows of %s", len(problems), rows, path)
+		}
+
+		printToStdOut(fmt.Sprintf("%s is valid: %d rows\n", path, rows))
+
+		return nil
+	},
+}
+
+func init() {
+	manifestValidateCmd.Flags().BoolVar(&checkFilesExist, "check-files", false, "Also report listed files that no longer exist")
+
+	manifestCmd.AddCommand(manifestValidateCmd)
+	rootCmd.AddCommand(manifestCmd)
+}
+
+// manifestRow is a row of a results file being validated, with where it
+// was found.
+type manifestRow struct {
+	row  int
+	file HashedFileInfo
+}
+
+func (r manifestRow) String() string {
+	return fmt.Sprintf("row %d (%s)", r.row, r.file.Path)
+}
+
+// validateManifest reads the results CSV in r and returns its number of
+// rows and a description of every problem found. It only fails if reading
+// cannot go on, such as when the header is unusable.
+func validateManifest(r io.Reader, source string) (int, []string, error) {
+	reader, err := newResultsReader(r, source)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer reader.Close()
+
+	var problems []string
+	var hashed []manifestRow
+	byPath := make(map[string][]int)
+
+	row := 0
+	for {
+		file, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		row++
+
+		// The CSV reader carries on after a malformed row or value, but
+		// not after a failed read of the file itself.
+		var parseErr *csv.ParseError
+		var numErr *strconv.NumError
+		var timeErr *time.ParseError
+		if errors.As(err, &parseErr) || errors.As(err, &numErr) || errors.As(err, &timeErr) {
+			problems = append(problems, fmt.Sprintf("row %d: %s", row, err))
+			continue
+		}
+		if err != nil {
+			return row, problems, err
+		}
+
+		current := manifestRow{row: row, file: file}
+
+		if file.Path == "" {
+			problems = append(problems, fmt.Sprintf("row %d: empty path", row))
+			continue
+		}
+		byPath[file.Path] = append(byPath[file.Path], row)
+
+		// Recorded symlinks are listed without a hash.
+		if file.Hash == "" && file.Symlink {
+			continue
+		}
+
+		switch {
+		case file.Hash == "":
+			problems = append(problems, fmt.Sprintf("%s: empty hash", current))
+		case strings.Trim(strings.ToLower(file.Hash), "0123456789abcdef") != "":
+			problems = append(problems, fmt.Sprintf("%s: hash %q is not hex", current, file.Hash))
+		default:
+			hashed = append(hashed, current)
+		}
+
+		if checkFilesExist {
+			// Archive entries exist as long as their archive does.
+			onDisk, _, _ := strings.Cut(file.Path, archivePathSeparator)
+
+			_, err := os.Lstat(onDisk)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", current, describeMissing(err)))
+			}
+		}
+	}
+
+	problems = append(problems, checkManifestHashes(hashed)...)
+
+	var repeated []string
+	for path, rows := range byPath {
+		if len(rows) > 1 {
+			repeated = append(repeated, path)
+		}
+	}
+	sort.Slice(repeated, func(i, j int) bool {
+		return byPath[repeated[i]][0] < byPath[repeated[j]][0]
+	})
+
+	for _, path := range repeated {
+		rows := byPath[path]
+		numbers := make([]string, len(rows))
+		for i, row := range rows {
+			numbers[i] = fmt.Sprint(row)
+		}
+
+		problems = append(problems, fmt.Sprintf("%s is listed %d times, in rows %s", path, len(rows), strings.Join(numbers, ", ")))
+	}
+
+	return row, problems, nil
+}
+
+// checkManifestHashes reports the rows whose algorithm or hash length
+// differs from that of most rows, and an algorithm whose digests are
+// shorter than the recorded hashes. --hash-length shortens every hash of a
+// scan, so shorter hashes are fine as long as all rows share the length.
+func checkManifestHashes(rows []manifestRow) []string {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	algoCounts := make(map[string]int)
+	lengthCounts := make(map[int]int)
+	for _, row := range rows {
+		algoCounts[row.file.Algorithm]++
+		lengthCounts[len(row.file.Hash)]++
+	}
+
+	// Ties go to the row that comes first.
+	algo, length := rows[0].file.Algorithm, len(rows[0].file.Hash)
+	for _, row := range rows {
+		if algoCounts[row.file.Algorithm] > algoCounts[algo] {
+			algo = row.file.Algorithm
+		}
+		if lengthCounts[len(row.file.Hash)] > lengthCounts[length] {
+			length = len(row.file.Hash)
+		}
+	}
+
+	var problems []string
+	for _, row := range rows {
+		if row.file.Algorithm != algo {
+			problems = append(problems, fmt.Sprintf("%s: algorithm %q differs from the %q of the other rows", row, row.file.Algorithm, algo))
+			continue
+		}
+		if len(row.file.Hash) != length {
+			problems = append(problems, fmt.Sprintf("%s: hash has %d hex digits, the other rows %d", row, len(row.file.Hash), length))
+		}
+	}
+
+	// Results written before the Algorithm column existed do not name it.
+	if algo == "" {
+		return problems
+	}
+
+	hasher, err := newHasher(algo)
+	if err != nil {
+		return append(problems, fmt.Sprintf("the rows were hashed with an unknown algorithm: %s", err))
+	}
+
+	if digits := hasher.Size() * 2; length > digits {
+		problems = append(problems, fmt.Sprintf("the rows have %d-digit hashes, but %s hashes have %d", length, algo, digits))
+	}
+
+	return problems
+}
+
+func describeMissing(err error) string {
+	if errors.Is(err, os.ErrNotExist) {
+		return "file no longer exists"
+	}
+
+	return err.Error()
+}
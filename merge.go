@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var mergeOutput string
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge <results.csv>...",
+	Short: "Combine results files from several scans to find duplicates across them",
+	Long: `merge reads the results CSVs written by several dupe-d scans, typically run on
+different machines, and writes them to a single results file. Each path is
+prefixed with the name of the results file it came from, without its extension,
+so "web1.csv" contributes paths like "web1:/var/www/logo.png". Rows that appear
+more than once are written once. The combined files are then grouped by hash and
+the summary reports the duplicates, including those spanning several results
+files. Use the same --algo and --hash-* settings for every scan being merged.`,
+	Example: `  dupe-d merge web1.csv web2.csv db1.csv -o combined.csv
+  dupe-d merge laptop.csv https://backup.example.com/nas.csv -o all.json`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		writer, err := resultsWriterFor(mergeOutput)
+		if err != nil {
+			return err
+		}
+
+		err = validateMatchOn(matchOn)
+		if err != nil {
+			return err
+		}
+
+		files, err := mergeManifests(args)
+		if err != nil {
+			return err
+		}
+
+		err = writer(files, mergeOutput)
+		if err != nil {
+			return err
+		}
+
+		groups := findDuplicateGroups(files, minGroupSize, matchOn)
+
+		var spanning int
+		for _, group := range groups {
+			if spansSources(group) {
+				spanning++
+			}
+		}
+
+		printToStdOut(fmt.Sprintf("%d of %d duplicate groups span more than one results file\n", spanning, len(groups)))
+		printSummary(files, groups)
+
+		return nil
+	},
+}
+
+func init() {
+	mergeCmd.Flags().StringVarP(&mergeOutput, "output", "o", "", "Write the combined results to this file (.csv or .json)")
+	mergeCmd.Flags().IntVar(&minGroupSize, "min-group-size", 2, "Only count duplicate groups with at least this many files")
+	mergeCmd.Flags().StringVar(&matchOn, "match-on", matchHash, "What files must share to be duplicates: "+strings.Join(matchModes, ", "))
+	mergeCmd.Flags().BoolVar(&csvBOM, "csv-bom", false, "Start a CSV output file with a UTF-8 byte order mark")
+	mergeCmd.MarkFlagRequired("output")
+
+	rootCmd.AddCommand(mergeCmd)
+}
+
+// mergeManifests loads every manifest and returns their rows in order with
+// each path prefixed by the manifest's label. Identical rows are kept once.
+func mergeManifests(locations []string) ([]HashedFileInfo, error) {
+	labels := make(map[string]string)
+	seen := make(map[string]bool)
+
+	// Hashes from different algorithms never match, so every manifest
+	// has to use the same one.
+	var algo, algoSource string
+	var unrecorded []string
+
+	var files []HashedFileInfo
+
+	for _, location := range locations {
+		// The parts and shards of a results file split by
+		// --max-output-size or --shards share the label of the first.
+		label := manifestLabel(location)
+		if other, ok := labels[label]; ok && unrotatedName(other) != unrotatedName(location) {
+			return nil, fmt.Errorf("%s and %s would both be labelled %q; rename one of them", other, location, label)
+		}
+		labels[label] = location
+
+		manifest, err := loadManifest(location, verifyTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range manifest {
+			if file.Hash != "" && file.Algorithm == "" && !slices.Contains(unrecorded, location) {
+				unrecorded = append(unrecorded, location)
+			}
+			if file.Algorithm != "" && algo == "" {
+				algo, algoSource = file.Algorithm, location
+			}
+			if file.Algorithm != "" && file.Algorithm != algo {
+				return nil, fmt.Errorf("%s was written with --algo %s but %s with --algo %s; only results of the same algorithm can be merged", algoSource, algo, location, file.Algorithm)
+			}
+
+			file.Path = label + ":" + file.Path
+			// A hard link names another row of the same results.
+			if file.LinkedTo != "" && !file.Symlink {
+				file.LinkedTo = label + ":" + file.LinkedTo
+			}
+			key := strings.Join(csvRecord(file), "\x00")
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			files = append(files, file)
+		}
+	}
+
+	// The combined results record the algorithm of their inputs, also for
+	// rows from results written before the Algorithm column existed.
+	if algo != "" {
+		if len(unrecorded) > 0 {
+			printWarning(fmt.Sprintf("no hash algorithm recorded in %s; assuming %s as in %s", strings.Join(unrecorded, ", "), algo, algoSource))
+		}
+
+		hashOpts.algo = algo
+	}
+
+	return files, nil
+}
+
+// manifestLabel names the source of a manifest's rows: its file name
+// without the extension, the part number and the shard of a split results
+// file.
+func manifestLabel(location string) string {
+	location = unrotatedName(location)
+
+	name := filepath.Base(location)
+	if isURL(location) {
+		name = location[strings.LastIndex(location, "/")+1:]
+	}
+
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// unrotatedName returns location without the part number rotatedFilename
+// adds and the hash prefix shardFilename adds, so every part and shard of
+// one scan's results has the name of the first.
+func unrotatedName(location string) string {
+	base := location
+	suffix := ""
+	if strings.HasSuffix(strings.ToLower(base), gzipExtension) {
+		base, suffix = base[:len(base)-len(gzipExtension)], base[len(base)-len(gzipExtension):]
+	}
+
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+
+	dot := strings.LastIndex(stem, ".")
+	if dot >= 0 && dot < len(stem)-1 && strings.Trim(stem[dot+1:], "0123456789") == "" {
+		stem = stem[:dot]
+	}
+
+	// The shards written with --shards belong to the same results too.
+	dot = strings.LastIndex(stem, ".shard-")
+	if dot >= 0 && dot+len(".shard-") < len(stem) && strings.Trim(stem[dot+len(".shard-"):], "0123456789abcdef") == "" {
+		stem = stem[:dot]
+	}
+
+	return stem + ext + suffix
+}
+
+// spansSources reports whether the files of a merged group came from more
+// than one manifest.
+func spansSources(group []HashedFileInfo) bool {
+	first, _, _ := strings.Cut(group[0].Path, ":")
+
+	for _, file := range group[1:] {
+		label, _, _ := strings.Cut(file.Path, ":")
+		if label != first {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var nameDupes bool
+
+func init() {
+	rootCmd.Flags().BoolVar(&nameDupes, "name-dupes", false, "Only walk the directory and list files that share a name, without hashing or comparing their content")
+}
+
+// printNameDupes walks folderPaths like a scan but hashes nothing, and
+// prints the files whose name is shared by at least minGroupSize files.
+// These are name matches only; the files may or may not have the same
+// content.
+func printNameDupes(ctx context.Context, folderPaths []string, opts scanOptions, minGroupSize int) error {
+	opts.archives.enabled = false
+	// Empty files have names like any other.
+	opts.emptyFiles = emptyUnique
+
+	files, failures, err := collectRoots(ctx, folderPaths, opts)
+	if err != nil {
+		return err
+	}
+
+	// macOS stores names decomposed (NFD) where other systems use the
+	// composed form (NFC), so names are compared in NFC to match "café"
+	// written either way.
+	var order []string
+	byName := make(map[string][]string)
+	for _, file := range files {
+		name := norm.NFC.String(file.Name)
+		if _, ok := byName[name]; !ok {
+			order = append(order, name)
+		}
+		byName[name] = append(byName[name], file.Path)
+	}
+
+	var names []string
+	var matched int
+	for _, name := range order {
+		if len(byName[name]) >= minGroupSize {
+			names = append(names, name)
+			matched += len(byName[name])
+		}
+	}
+
+	sort.SliceStable(names, func(i, j int) bool {
+		return len(byName[names[i]]) > len(byName[names[j]])
+	})
+
+	var sb strings.Builder
+
+	sb.WriteString("Files sharing a name (matched by name only, their content was not compared):\n")
+	for _, name := range names {
+		fmt.Fprintf(&sb, "\n%s (%d files)\n", name, len(byName[name]))
+		for _, path := range byName[name] {
+			fmt.Fprintf(&sb, "  %s\n", path)
+		}
+	}
+	fmt.Fprintf(&sb, "\n%d names shared by %d files\n", len(names), matched)
+
+	fmt.Fprint(os.Stdout, sb.String())
+
+	if len(failures) > 0 {
+		return &ScanErrors{errs: failures}
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// contentNormalizer selects the part of a file's content that is hashed, so
+// that differences which don't matter for duplicate detection (such as
+// embedded metadata) are ignored.
+type contentNormalizer interface {
+	// normalize returns a reader over the bytes of r that should be hashed.
+	// size is the total length of r.
+	normalize(r io.ReaderAt, size int64) (io.Reader, error)
+}
+
+type normalizerRegistration struct {
+	exts       []string
+	normalizer contentNormalizer
+}
+
+// contentNormalizers lists the normalizers selectable with --normalize and
+// the file extensions each one applies to.
+var contentNormalizers = map[string]normalizerRegistration{
+	"mp3": {exts: []string{".mp3"}, normalizer: mp3Normalizer{}},
+}
+
+func normalizerNames() []string {
+	names := make([]string, 0, len(contentNormalizers))
+	for name := range contentNormalizers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// buildNormalizers maps the extensions covered by the named normalizers,
+// and the text extensions whose line endings are normalized, to the
+// normalizer that handles them. Extensions in trimExts have trailing NUL
+// bytes stripped before any other normalizer sees the content.
+func buildNormalizers(names []string, eolExts []string, trimExts []string) (map[string]contentNormalizer, error) {
+	if len(names) == 0 && len(eolExts) == 0 && len(trimExts) == 0 {
+		return nil, nil
+	}
+
+	byExt := make(map[string]contentNormalizer)
+
+	for _, ext := range eolExts {
+		byExt[strings.ToLower(ext)] = eolNormalizer{}
+	}
+
+	for _, name := range names {
+		registration, ok := contentNormalizers[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown normalizer %q (expected one of: %s)", name, strings.Join(normalizerNames(), ", "))
+		}
+
+		for _, ext := range registration.exts {
+			byExt[ext] = registration.normalizer
+		}
+	}
+
+	for _, ext := range trimExts {
+		ext = strings.ToLower(ext)
+		byExt[ext] = trailingNullNormalizer{next: byExt[ext]}
+	}
+
+	return byExt, nil
+}
+
+// mp3Normalizer hashes only the audio frames of an MP3 file by skipping any
+// ID3v2 tags at the start and an ID3v1 tag at the end.
+type mp3Normalizer struct{}
+
+func (mp3Normalizer) normalize(r io.ReaderAt, size int64) (io.Reader, error) {
+	var start int64
+	header := make([]byte, 10)
+
+	// Files can carry more than one ID3v2 tag back to back.
+	for start+10 <= size {
+		_, err := r.ReadAt(header, start)
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.Equal(header[:3], []byte("ID3")) {
+			break
+		}
+
+		// The tag size is a 28-bit "syncsafe" integer: 7 bits per byte.
+		tagSize := int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9])
+		start += 10 + tagSize
+
+		// A footer, signalled by bit 4 of the flags, adds another 10 bytes.
+		if header[5]&0x10 != 0 {
+			start += 10
+		}
+	}
+
+	end := size
+	if end-start >= 128 {
+		marker := make([]byte, 3)
+
+		_, err := r.ReadAt(marker, end-128)
+		if err != nil {
+			return nil, err
+		}
+
+		if bytes.Equal(marker, []byte("TAG")) {
+			end -= 128
+		}
+	}
+
+	if start > end {
+		start = end
+	}
+
+	return io.NewSectionReader(r, start, end-start), nil
+}
+
+// trailingNullNormalizer hashes a file without the run of NUL bytes at its
+// end, so copies padded to different block boundaries match. NULs anywhere
+// else are kept. The rest of the content is handed to next, if set.
+type trailingNullNormalizer struct {
+	next contentNormalizer
+}
+
+func (t trailingNullNormalizer) normalize(r io.ReaderAt, size int64) (io.Reader, error) {
+	end, err := trimmedLength(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.next != nil {
+		return t.next.normalize(io.NewSectionReader(r, 0, end), end)
+	}
+
+	return io.NewSectionReader(r, 0, end), nil
+}
+
+// trimmedLength returns the length of r once trailing NUL bytes are left
+// off. Only the padding itself is read, a block at a time from the end.
+func trimmedLength(r io.ReaderAt, size int64) (int64, error) {
+	block := make([]byte, 64*1024)
+	end := size
+
+	for end > 0 {
+		n := min(int64(len(block)), end)
+
+		_, err := r.ReadAt(block[:n], end-n)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+
+		for i := n - 1; i >= 0; i-- {
+			if block[i] != 0 {
+				return end - n + i + 1, nil
+			}
+		}
+
+		end -= n
+	}
+
+	return 0, nil
+}
+
+// binarySniffLength is how much of a file eolNormalizer inspects to decide
+// whether it is text.
+const binarySniffLength = 8000
+
+// eolNormalizer hashes text files with every CRLF or lone CR line ending
+// turned into LF, so copies saved on Windows and Unix match. Files with a
+// NUL byte near the start are taken to be binary and hashed as-is.
+type eolNormalizer struct{}
+
+func (eolNormalizer) normalize(r io.ReaderAt, size int64) (io.Reader, error) {
+	head := make([]byte, min(size, binarySniffLength))
+
+	_, err := r.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	content := io.NewSectionReader(r, 0, size)
+	if bytes.IndexByte(head, 0) != -1 {
+		return content, nil
+	}
+
+	return &eolReader{r: content}, nil
+}
+
+// eolReader rewrites line endings to LF as it reads.
+type eolReader struct {
+	r         io.Reader
+	pendingCR bool
+}
+
+func (e *eolReader) Read(p []byte) (int, error) {
+	// An empty read would never produce output, so the loop below would
+	// not end.
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for {
+		n, err := e.r.Read(p)
+
+		// The output is never longer than the input, so the conversion
+		// can be done in place.
+		out := 0
+		for _, b := range p[:n] {
+			switch {
+			case b == '\n' && e.pendingCR:
+				e.pendingCR = false
+			case b == '\r':
+				p[out] = '\n'
+				out++
+				e.pendingCR = true
+			default:
+				p[out] = b
+				out++
+				e.pendingCR = false
+			}
+		}
+
+		if out > 0 || err != nil {
+			return out, err
+		}
+	}
+}
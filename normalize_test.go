@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// scanGroups scans dir with opts and returns the names of the files in each
+// duplicate group.
+func scanGroups(t *testing.T, dir string, opts scanOptions) [][]string {
+	t.Helper()
+
+	files, err := processFiles(context.Background(), []string{dir}, opts)
+	if err != nil {
+		t.Fatalf("scan failed: %v", err)
+	}
+
+	var groups [][]string
+	for _, group := range findDuplicateGroups(files, 2, matchHash) {
+		var names []string
+		for _, file := range group {
+			names = append(names, filepath.Base(file.Path))
+		}
+		slices.Sort(names)
+		groups = append(groups, names)
+	}
+	slices.SortFunc(groups, slices.Compare)
+
+	return groups
+}
+
+func TestNormalizeEOLGroupsLineEndingVariants(t *testing.T) {
+	quietOutput(t)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"unix.txt":    "one\ntwo\n",
+		"windows.txt": "one\r\ntwo\r\n",
+		"mac.txt":     "one\rtwo\r",
+		"other.txt":   "one\n\ntwo\n",
+		"binary.txt":  "\x00one\r\ntwo\r\n",
+		"binary2.txt": "\x00one\ntwo\n",
+		"unix.md":     "three\n",
+		"windows.md":  "three\r\n",
+	})
+
+	normalizers, err := buildNormalizers(nil, []string{".txt"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := testScanOptions()
+	opts.hash.normalizers = normalizers
+
+	// Only text files with a selected extension are normalized; "one\n\n"
+	// is a blank line more, not another line ending.
+	want := [][]string{{"mac.txt", "unix.txt", "windows.txt"}}
+	if groups := scanGroups(t, dir, opts); !slices.EqualFunc(groups, want, slices.Equal) {
+		t.Errorf("duplicate groups are %v, want %v", groups, want)
+	}
+}
+
+func TestEOLReaderHandlesCRLFSplitAcrossReads(t *testing.T) {
+	input := "a\r\nb\r\r\nc\rd\r"
+
+	r, err := eolNormalizer{}.normalize(strings.NewReader(input), int64(len(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading a byte at a time splits every CRLF over two reads.
+	got, err := io.ReadAll(iotest.OneByteReader(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "a\nb\n\nc\nd\n"; string(got) != want {
+		t.Errorf("normalized %q to %q, want %q", input, got, want)
+	}
+}
+
+func TestTrimTrailingNullsGroupsPaddedCopies(t *testing.T) {
+	quietOutput(t)
+
+	// The padding of large.bin spans more than one of the blocks that
+	// trimmedLength reads from the end.
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"plain.bin":  "data",
+		"padded.bin": "data\x00\x00\x00",
+		"large.bin":  "data" + strings.Repeat("\x00", 200*1024),
+		"inner.bin":  "da\x00ta",
+		"nulls.bin":  "\x00\x00",
+		"padded.txt": "data\x00",
+		"crlf.txt":   "data\r\n\x00\x00",
+		"lf.txt":     "data\n",
+	})
+
+	normalizers, err := buildNormalizers(nil, []string{".txt"}, []string{".bin", ".txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := testScanOptions()
+	opts.hash.normalizers = normalizers
+
+	// NULs inside the content are kept, and with --normalize-eol as well
+	// the padding is trimmed before line endings are converted.
+	want := [][]string{{"crlf.txt", "lf.txt"}, {"large.bin", "padded.bin", "padded.txt", "plain.bin"}}
+	if groups := scanGroups(t, dir, opts); !slices.EqualFunc(groups, want, slices.Equal) {
+		t.Errorf("duplicate groups are %v, want %v", groups, want)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	orderWalk     = "walk"
+	orderSizeAsc  = "size-asc"
+	orderSizeDesc = "size-desc"
+)
+
+var orderModes = []string{orderWalk, orderSizeAsc, orderSizeDesc}
+
+var hashOrder string
+
+func init() {
+	rootCmd.Flags().StringVar(&hashOrder, "order", orderWalk, "Order to hash files in: "+strings.Join(orderModes, ", ")+"; the results keep the walk order")
+}
+
+func validateOrder(mode string) error {
+	if !slices.Contains(orderModes, mode) {
+		return fmt.Errorf("unknown order %q (expected one of: %s)", mode, strings.Join(orderModes, ", "))
+	}
+
+	return nil
+}
+
+// dispatchOrder returns the indexes of candidates in the order they should
+// be handed to the workers. Files of the same size keep their walk order.
+func dispatchOrder(candidates []HashedFileInfo, mode string) []int {
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+
+	switch mode {
+	case orderSizeAsc:
+		sort.SliceStable(order, func(a, b int) bool {
+			return candidates[order[a]].Size < candidates[order[b]].Size
+		})
+	case orderSizeDesc:
+		sort.SliceStable(order, func(a, b int) bool {
+			return candidates[order[a]].Size > candidates[order[b]].Size
+		})
+	}
+
+	return order
+}
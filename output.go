@@ -0,0 +1,297 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type resultsWriter func(files []HashedFileInfo, filename string) error
+
+// outputFormats maps the file extensions accepted by --output to the writer
+// for that format.
+var outputFormats = map[string]resultsWriter{
+	".csv":  writeToCsv,
+	".html": writeToHtml,
+	".json": writeToJson,
+}
+
+func outputFormatNames() []string {
+	names := make([]string, 0, len(outputFormats))
+	for ext := range outputFormats {
+		names = append(names, ext)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// gzipExtension, added after the format's extension, makes an output file
+// gzip-compressed.
+const gzipExtension = ".gz"
+
+func resultsWriterFor(filename string) (resultsWriter, error) {
+	name := strings.TrimSuffix(strings.ToLower(filename), gzipExtension)
+
+	writer, ok := outputFormats[filepath.Ext(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown output format for %s (expected one of: %s, optionally followed by %s)", filename, strings.Join(outputFormatNames(), ", "), gzipExtension)
+	}
+
+	return writer, nil
+}
+
+// createOutput creates filename for writing. Output to a name ending in
+// .gz is gzip-compressed on the fly.
+func createOutput(filename string) (io.WriteCloser, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(strings.ToLower(filename), gzipExtension) {
+		return file, nil
+	}
+
+	return &gzipFile{Writer: gzip.NewWriter(file), file: file}, nil
+}
+
+// gzipFile compresses everything written to it into file.
+type gzipFile struct {
+	*gzip.Writer
+	file *os.File
+}
+
+// Close finishes the compressed stream and closes the file.
+func (g *gzipFile) Close() error {
+	err := g.Writer.Close()
+	closeErr := g.file.Close()
+	if err != nil {
+		return err
+	}
+
+	return closeErr
+}
+
+func validateOutputFiles(filenames []string) error {
+	for _, filename := range filenames {
+		_, err := resultsWriterFor(filename)
+		if err != nil {
+			return err
+		}
+
+		if maxOutputSize > 0 && filepath.Ext(strings.TrimSuffix(strings.ToLower(filename), gzipExtension)) != ".csv" {
+			return fmt.Errorf("--max-output-size only splits CSV output, not %s", filename)
+		}
+	}
+
+	return nil
+}
+
+// writeResults writes files to every requested output, or to a timestamped
+// CSV file when no --output was given, and returns the names written.
+func writeResults(files []HashedFileInfo) ([]string, error) {
+	var written []string
+
+	for _, filename := range getResultsFilenames() {
+		writer, err := resultsWriterFor(filename)
+		if err != nil {
+			return written, err
+		}
+
+		err = writer(files, filename)
+		if err != nil {
+			return written, err
+		}
+
+		written = append(written, filename)
+	}
+
+	return written, nil
+}
+
+// stdoutFormats are the views of the results selectable with --format,
+// printed to stdout once the results files are written.
+var stdoutFormats = map[string]func(files []HashedFileInfo, groups [][]HashedFileInfo){
+	"paths":   printPaths,
+	"summary": printSummary,
+	"tree":    printTree,
+}
+
+func stdoutFormatNames() []string {
+	names := make([]string, 0, len(stdoutFormats))
+	for name := range stdoutFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func validateStdoutFormat(name string) error {
+	if _, ok := stdoutFormats[name]; !ok {
+		return fmt.Errorf("unknown format %q (expected one of: %s)", name, strings.Join(stdoutFormatNames(), ", "))
+	}
+
+	return nil
+}
+
+// jsonVersion is the version of the JSON results envelope. Bump it whenever
+// the structure of the envelope or its records changes.
+//
+// Version 2 added the allocated field of records and the match_on,
+// one_file_system, cross_device_allow, normalize_eol, trim_trailing_nulls,
+// zero_size, uniques_only and duplicates_only options.
+const jsonVersion = 2
+
+type jsonEnvelope struct {
+	Version     int          `json:"version"`
+	GeneratedAt time.Time    `json:"generated_at"`
+	Options     jsonOptions  `json:"options"`
+	Results     []jsonRecord `json:"results"`
+}
+
+// jsonOptions records the settings that affect which files were hashed and
+// how their hashes were computed.
+type jsonOptions struct {
+	Algorithm         string   `json:"algorithm"`
+	HashLength        int      `json:"hash_length,omitempty"`
+	HashIncludeName   bool     `json:"hash_include_name"`
+	HashIncludeMode   bool     `json:"hash_include_mode"`
+	Normalize         []string `json:"normalize"`
+	NormalizeEOL      []string `json:"normalize_eol"`
+	TrimTrailingNulls []string `json:"trim_trailing_nulls"`
+	DecompressCompare bool     `json:"decompress_compare"`
+	Extensions        []string `json:"extensions"`
+	SkipFilesMatching string   `json:"skip_files_matching,omitempty"`
+	SkipVCS           bool     `json:"skip_vcs"`
+	OneFileSystem     bool     `json:"one_file_system"`
+	CrossDeviceAllow  []string `json:"cross_device_allow,omitempty"`
+	IncludeSpecial    bool     `json:"include_special"`
+	ScanArchives      bool     `json:"scan_archives"`
+	ArchiveDepth      int      `json:"archive_depth,omitempty"`
+	MinGroupSize      int      `json:"min_group_size"`
+	MatchOn           string   `json:"match_on"`
+	ZeroSize          string   `json:"zero_size"`
+	KeepersOnly       bool     `json:"keepers_only"`
+	UniquesOnly       bool     `json:"uniques_only"`
+	DuplicatesOnly    bool     `json:"duplicates_only"`
+	Keep              string   `json:"keep,omitempty"`
+}
+
+func currentJsonOptions() jsonOptions {
+	// The presets were validated before the scan.
+	exts, _ := selectedExtensions()
+
+	options := jsonOptions{
+		Algorithm:         hashOpts.algo,
+		HashLength:        hashOpts.length,
+		HashIncludeName:   hashOpts.includeName,
+		HashIncludeMode:   hashOpts.includeMode,
+		Normalize:         append([]string{}, normalize...),
+		NormalizeEOL:      append([]string{}, formatExtensions(normalizeEOL)...),
+		TrimTrailingNulls: append([]string{}, formatExtensions(trimNulls)...),
+		DecompressCompare: hashOpts.decompress,
+		Extensions:        append([]string{}, exts...),
+		SkipFilesMatching: skipPattern,
+		SkipVCS:           skipVCS,
+		OneFileSystem:     oneFileSystem || len(crossDevice) > 0,
+		CrossDeviceAllow:  crossDevice,
+		IncludeSpecial:    includeSpecial,
+		ScanArchives:      archiveOpts.enabled,
+		MinGroupSize:      minGroupSize,
+		MatchOn:           matchOn,
+		ZeroSize:          emptyFilesMode(),
+		KeepersOnly:       keepersOnly,
+		UniquesOnly:       uniquesOnly,
+		DuplicatesOnly:    duplicatesOnly,
+	}
+
+	if archiveOpts.enabled {
+		options.ArchiveDepth = archiveOpts.maxDepth
+	}
+
+	if keepersOnly {
+		options.Keep = keepStrategy
+	}
+
+	return options
+}
+
+type jsonRecord struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Hash     string    `json:"hash"`
+	Link     string    `json:"link,omitempty"`
+	Modified time.Time `json:"modified"`
+	RawHash  string    `json:"raw_hash,omitempty"`
+	// Allocated is only recorded for sparse files.
+	Allocated *int64 `json:"allocated,omitempty"`
+}
+
+func writeToJson(hashedFilesInfo []HashedFileInfo, outputFilename string) error {
+	records := make([]jsonRecord, 0, len(hashedFilesInfo))
+	for _, hashedFileInfo := range hashedFilesInfo {
+		record := jsonRecord{
+			Name:     hashedFileInfo.Name,
+			Path:     hashedFileInfo.Path,
+			Size:     hashedFileInfo.Size,
+			Hash:     hashedFileInfo.Hash,
+			Link:     linkDescription(hashedFileInfo),
+			Modified: hashedFileInfo.ModTime,
+			RawHash:  hashedFileInfo.RawHash,
+		}
+		if hashedFileInfo.Sparse {
+			record.Allocated = &hashedFileInfo.Allocated
+		}
+
+		records = append(records, record)
+	}
+
+	file, err := createOutput(outputFilename)
+	if err != nil {
+		return fmt.Errorf("failed to create JSON file: %w", err)
+	}
+	defer file.Close()
+
+	var content any = jsonEnvelope{
+		Version:     jsonVersion,
+		GeneratedAt: outputTime(),
+		Options:     currentJsonOptions(),
+		Results:     records,
+	}
+
+	if legacyJson {
+		content = records
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+
+	err = encoder.Encode(content)
+	if err != nil {
+		return fmt.Errorf("failed to write content to JSON: %w", err)
+	}
+
+	// Closing finishes a compressed stream, so its error matters.
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write JSON file: %w", err)
+	}
+
+	absPath, err := filepath.Abs(outputFilename)
+	if err != nil {
+		absPath = outputFilename
+	}
+
+	printToStdOut(fmt.Sprintf("Output written to: %s\n", absPath))
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+)
+
+var (
+	includeKeepers bool
+	print0         bool
+)
+
+func init() {
+	rootCmd.Flags().BoolVar(&includeKeepers, "include-keepers", false, "With --format paths, also print the file kept in each duplicate group")
+	rootCmd.Flags().BoolVar(&print0, "print0", false, "With --format paths, end each path with a NUL byte instead of a newline")
+}
+
+// printPaths prints the paths of the redundant copies in each duplicate
+// group, one per line, for piping into other tools. The kept file of a
+// group is chosen as for --keepers-only and is left out unless
+// --include-keepers is given. Nothing else is printed, so --format paths
+// turns the other output off.
+func printPaths(files []HashedFileInfo, groups [][]HashedFileInfo) {
+	terminator := "\n"
+	if print0 {
+		terminator = "\x00"
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	for _, group := range groups {
+		keepers := selectKeepers(group, keepStrategy, canonicalDir)
+
+		for i, file := range group {
+			if keepers[i] == i && !includeKeepers {
+				continue
+			}
+
+			fmt.Fprint(out, file.Path, terminator)
+		}
+	}
+}
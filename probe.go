@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+)
+
+// probeAccess checks that the files selected by opts can be read without
+// hashing them. Every directory is walked, but only about fraction of the
+// files are opened, spread evenly over the tree, so the check stays quick
+// on large trees.
+func probeAccess(ctx context.Context, folderPaths []string, opts scanOptions, fraction float64) error {
+	opts.strict = false
+
+	for _, folderPath := range folderPaths {
+		printToStdOut(fmt.Sprintf("Probing read access in: %s\n", folderPath))
+	}
+
+	candidates, failures, err := collectRoots(ctx, folderPaths, opts)
+	if err != nil {
+		return err
+	}
+
+	step := max(1, int(math.Round(1/fraction)))
+
+	probed := 0
+	for i := 0; i < len(candidates); i += step {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		probed++
+
+		file, err := os.Open(candidates[i].Path)
+		if err != nil {
+			failures = append(failures, FileError{Path: candidates[i].Path, Err: err})
+			continue
+		}
+
+		file.Close()
+	}
+
+	for _, failure := range failures {
+		printToStdOut(fmt.Sprintf("Not readable: %s\n", failure))
+	}
+
+	printToStdOut(fmt.Sprintf("Probed %d of %d files: %d paths not readable\n", probed, len(candidates), len(failures)))
+
+	if len(failures) > 0 {
+		return fmt.Errorf("probe found %d paths that cannot be read", len(failures))
+	}
+
+	return nil
+}
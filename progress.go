@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// etaWindow is how far back throughput samples are kept when averaging.
+	etaWindow = 10 * time.Second
+	// etaInterval is how often the displayed ETA is recomputed, so the value
+	// does not jump around from one file to the next.
+	etaInterval = time.Second
+	// fileReportInterval is the least time between two per-file progress
+	// lines, so a scan of many small files is not slowed down by printing
+	// one line for each of them.
+	fileReportInterval = 100 * time.Millisecond
+)
+
+type progressSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// progressTracker reports how far through the scan we are by bytes hashed and
+// estimates the time remaining from the rolling average throughput. It is
+// safe for concurrent use.
+type progressTracker struct {
+	mu         sync.Mutex
+	totalBytes int64
+	doneBytes  int64
+	samples    []progressSample
+	eta        time.Duration
+	hasETA     bool
+	computedAt time.Time
+	reportedAt time.Time
+	// readBytes counts the bytes actually read from files, leaving out
+	// those of files whose hash was reused.
+	readBytes int64
+	readLimit int64
+	onLimit   func()
+	// For --progress-file, which gets an event at most every
+	// fileReportInterval as files are read and finished.
+	totalFiles int
+	doneFiles  int
+	events     *json.Encoder
+	eventAt    time.Time
+}
+
+// progressEvent is one line of --progress-file output.
+type progressEvent struct {
+	FilesDone  int    `json:"files_done"`
+	FilesTotal int    `json:"files_total"`
+	BytesDone  int64  `json:"bytes_done"`
+	BytesTotal int64  `json:"bytes_total"`
+	Path       string `json:"path,omitempty"`
+	Done       bool   `json:"done,omitempty"`
+}
+
+func newProgressTracker(totalFiles int, totalBytes int64) *progressTracker {
+	return &progressTracker{
+		totalFiles: totalFiles,
+		totalBytes: totalBytes,
+		samples:    []progressSample{{at: time.Now()}},
+	}
+}
+
+// writeEventsTo makes the tracker write progress events to w as JSON
+// lines.
+func (p *progressTracker) writeEventsTo(w io.Writer) {
+	p.events = json.NewEncoder(w)
+}
+
+// fileDone counts the file at path as finished, whether it was hashed,
+// reused or failed.
+func (p *progressTracker) fileDone(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.doneFiles++
+	p.maybeWriteEvent(path)
+}
+
+// reading reports that the file at path is being read, so that events keep
+// coming while a large file is hashed.
+func (p *progressTracker) reading(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.maybeWriteEvent(path)
+}
+
+func (p *progressTracker) maybeWriteEvent(path string) {
+	now := time.Now()
+	if p.events == nil || now.Sub(p.eventAt) < fileReportInterval {
+		return
+	}
+
+	p.eventAt = now
+	p.writeEvent(progressEvent{Path: path})
+}
+
+// finishEvents writes the last progress event, once hashing has ended.
+func (p *progressTracker) finishEvents() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.events != nil {
+		p.writeEvent(progressEvent{Done: true})
+	}
+}
+
+func (p *progressTracker) writeEvent(event progressEvent) {
+	event.FilesDone, event.FilesTotal = p.doneFiles, p.totalFiles
+	event.BytesDone, event.BytesTotal = p.doneBytes, p.totalBytes
+
+	// A reader that went away does not stop the scan; it only ends the
+	// events.
+	err := p.events.Encode(event)
+	if err != nil {
+		printWarning(fmt.Sprintf("failed to write progress event, no further events are written: %s", err))
+		p.events = nil
+	}
+}
+
+// limitReads calls onLimit once limit bytes have been read from files.
+func (p *progressTracker) limitReads(limit int64, onLimit func()) {
+	p.readLimit = limit
+	p.onLimit = onLimit
+}
+
+// read adds n bytes read from a file.
+func (p *progressTracker) read(n int64) {
+	p.add(n)
+
+	p.mu.Lock()
+	p.readBytes += n
+	reached := p.readLimit > 0 && p.readBytes >= p.readLimit
+	p.mu.Unlock()
+
+	if reached {
+		p.onLimit()
+	}
+}
+
+// bytesRead returns how many bytes have been read from files so far.
+func (p *progressTracker) bytesRead() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.readBytes
+}
+
+func (p *progressTracker) add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.doneBytes += n
+
+	now := time.Now()
+	p.samples = append(p.samples, progressSample{at: now, bytes: p.doneBytes})
+
+	for len(p.samples) > 2 && now.Sub(p.samples[0].at) > etaWindow {
+		p.samples = p.samples[1:]
+	}
+
+	if !p.hasETA || now.Sub(p.computedAt) >= etaInterval {
+		p.computeETA(now)
+	}
+}
+
+func (p *progressTracker) computeETA(now time.Time) {
+	oldest := p.samples[0]
+	elapsed := now.Sub(oldest.at).Seconds()
+	read := p.doneBytes - oldest.bytes
+
+	if elapsed <= 0 || read <= 0 {
+		return
+	}
+
+	throughput := float64(read) / elapsed
+	remaining := float64(p.totalBytes - p.doneBytes)
+
+	p.eta = time.Duration(remaining / throughput * float64(time.Second))
+	p.hasETA = true
+	p.computedAt = now
+}
+
+// due reports whether enough time has passed since the last per-file
+// progress line for another one to be printed. With --verbose every file
+// is reported.
+func (p *progressTracker) due() bool {
+	if verbose {
+		return true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.reportedAt) < fileReportInterval {
+		return false
+	}
+
+	p.reportedAt = now
+	return true
+}
+
+func (p *progressTracker) status() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	percent := 100.0
+	if p.totalBytes > 0 {
+		percent = float64(p.doneBytes) / float64(p.totalBytes) * 100
+	}
+
+	if !p.hasETA {
+		return fmt.Sprintf("%5.1f%%, ETA --:--:--", percent)
+	}
+
+	return fmt.Sprintf("%5.1f%%, ETA %s", percent, formatDuration(p.eta))
+}
+
+// forFile returns a tracker for the progress within the single file at
+// path, so that hashing a very large file still moves the overall progress
+// along.
+func (p *progressTracker) forFile(path string) *fileProgress {
+	return &fileProgress{tracker: p, path: path, printedAt: time.Now()}
+}
+
+// fileProgress feeds the bytes read from one file into its progressTracker
+// and, for files that take longer than etaInterval to hash, prints how far
+// through the file hashing is.
+type fileProgress struct {
+	tracker   *progressTracker
+	path      string
+	added     int64
+	printedAt time.Time
+}
+
+func (f *fileProgress) update(done int64, total int64) {
+	// A file read more than once (e.g. with --decompress-compare) only
+	// counts towards the overall progress once.
+	if done > f.added {
+		f.tracker.read(done - f.added)
+		f.added = done
+	}
+
+	f.tracker.reading(f.path)
+
+	now := time.Now()
+	if total <= 0 || now.Sub(f.printedAt) < etaInterval {
+		return
+	}
+
+	f.printedAt = now
+	printProgress(fmt.Sprintf("Hashing %s: %.0f%% [%s]\n", f.path, float64(done)/float64(total)*100, f.tracker.status()))
+}
+
+// finish accounts for whatever part of size was not read through update,
+// such as files reused from --resume or skipped by --ext.
+func (f *fileProgress) finish(size int64) {
+	f.tracker.add(size - f.added)
+}
+
+// progressReader reports the bytes read from file to onRead. Reads through
+// ReadAt count too, since normalizers hash the content through them;
+// hashFile resets done once a normalizer has found what to hash.
+type progressReader struct {
+	file   *os.File
+	total  int64
+	done   int64
+	onRead func(done int64, total int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	r.done += int64(n)
+	r.onRead(r.done, r.total)
+
+	return n, err
+}
+
+func (r *progressReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.file.ReadAt(p, off)
+	r.done += int64(n)
+	r.onRead(r.done, r.total)
+
+	return n, err
+}
+
+// formatDuration renders d as HH:MM:SS.
+func formatDuration(d time.Duration) string {
+	seconds := int64(d.Round(time.Second).Seconds())
+
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
@@ -0,0 +1,22 @@
+//go:build darwin
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates dst as a copy-on-write clone of src with clonefile(2),
+// which APFS supports.
+func cloneFile(src string, dst string) error {
+	err := unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW)
+	if errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EXDEV) {
+		return fmt.Errorf("%w: %w", errReflinkUnsupported, err)
+	}
+
+	return err
+}
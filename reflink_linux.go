@@ -0,0 +1,46 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates dst as a copy-on-write clone of src with the FICLONE
+// ioctl, which btrfs, XFS and some other file systems support.
+func cloneFile(src string, dst string) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	clone, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	err = unix.IoctlFileClone(int(clone.Fd()), int(source.Fd()))
+	closeErr := clone.Close()
+	if err != nil {
+		os.Remove(dst)
+
+		if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOTTY) ||
+			errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.EXDEV) {
+			return fmt.Errorf("%w: %w", errReflinkUnsupported, err)
+		}
+
+		return err
+	}
+	if closeErr != nil {
+		os.Remove(dst)
+		return closeErr
+	}
+
+	return nil
+}
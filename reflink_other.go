@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package main
+
+// cloneFile always fails where dupe-d cannot create reflinks.
+func cloneFile(src string, dst string) error {
+	return errReflinkUnsupported
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// defaultMaxOpenFiles uses a fixed limit where the open file limit cannot be
+// queried.
+func defaultMaxOpenFiles() int {
+	return fallbackMaxOpenFiles
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// defaultMaxOpenFiles allows half of the soft open file limit to be used for
+// hashing, leaving the rest for the output file, stdio and the runtime.
+func defaultMaxOpenFiles() int {
+	var limit syscall.Rlimit
+
+	err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit)
+	if err != nil || limit.Cur == 0 {
+		return fallbackMaxOpenFiles
+	}
+
+	if limit.Cur > 2*maxDefaultOpenFiles {
+		return maxDefaultOpenFiles
+	}
+
+	return max(1, int(limit.Cur/2))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var shards int
+
+func init() {
+	rootCmd.Flags().IntVar(&shards, "shards", 0, "Split CSV results into 16, 256 or 4096 files by the first 1, 2 or 3 hex digits of the hash")
+}
+
+// shardPrefixLen returns how many hex digits of the hash pick the shard of
+// a row, or 0 if the results are not sharded.
+func shardPrefixLen(n int) int {
+	switch n {
+	case 16:
+		return 1
+	case 256:
+		return 2
+	case 4096:
+		return 3
+	}
+
+	return 0
+}
+
+func validateShards(filenames []string) error {
+	if shards == 0 || shards == 1 {
+		return nil
+	}
+
+	digits := shardPrefixLen(shards)
+	if digits == 0 {
+		return fmt.Errorf("--shards must be 16, 256 or 4096: %d", shards)
+	}
+
+	if hashOpts.length > 0 && hashOpts.length < digits {
+		return fmt.Errorf("--shards %d needs hashes of at least %d hex characters, but --hash-length is %d", shards, digits, hashOpts.length)
+	}
+
+	for _, filename := range filenames {
+		if filepath.Ext(strings.TrimSuffix(strings.ToLower(filename), gzipExtension)) != ".csv" {
+			return fmt.Errorf("--shards only splits CSV output, not %s", filename)
+		}
+	}
+
+	return nil
+}
+
+// shardFiles partitions files by the first digits of their hash, keeping
+// their order within each shard. Rows without a hex hash, such as recorded
+// symlinks, go to the first shard.
+func shardFiles(files []HashedFileInfo, digits int) map[string][]HashedFileInfo {
+	byShard := make(map[string][]HashedFileInfo)
+	first := strings.Repeat("0", digits)
+
+	for _, file := range files {
+		prefix := first
+		if len(file.Hash) >= digits && strings.Trim(strings.ToLower(file.Hash[:digits]), "0123456789abcdef") == "" {
+			prefix = strings.ToLower(file.Hash[:digits])
+		}
+
+		byShard[prefix] = append(byShard[prefix], file)
+	}
+
+	return byShard
+}
+
+// shardFilename returns the name of the shard of results with the given
+// hash prefix, which goes before the extension: results.csv.gz holds the
+// rows whose hash starts with "a" in results.shard-a.csv.gz.
+func shardFilename(filename string, prefix string) string {
+	base := filename
+	suffix := ""
+	if strings.HasSuffix(strings.ToLower(base), gzipExtension) {
+		base, suffix = base[:len(base)-len(gzipExtension)], base[len(base)-len(gzipExtension):]
+	}
+
+	ext := filepath.Ext(base)
+
+	return fmt.Sprintf("%s.shard-%s%s%s", strings.TrimSuffix(base, ext), prefix, ext, suffix)
+}
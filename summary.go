@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+type extensionStats struct {
+	ext         string
+	files       int
+	groups      int
+	reclaimable int64
+}
+
+// reclaimableBytes is the space freed on disk by keeping the first file of
+// each group.
+func reclaimableBytes(groups [][]HashedFileInfo) int64 {
+	var total int64
+	for _, group := range groups {
+		for _, file := range group[1:] {
+			total += diskSize(file)
+		}
+	}
+
+	return total
+}
+
+// diskSize is the space file occupies on disk: its size, or less for a
+// sparse file.
+func diskSize(file HashedFileInfo) int64 {
+	if file.Sparse {
+		return file.Allocated
+	}
+
+	return file.Size
+}
+
+func summaryExtension(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == "" {
+		return "(none)"
+	}
+
+	return ext
+}
+
+// statsByExtension breaks the scan down by lower-cased file extension. A
+// group counts towards every extension among its members, and each
+// redundant copy's disk size counts towards its own extension.
+func statsByExtension(files []HashedFileInfo, groups [][]HashedFileInfo) []extensionStats {
+	byExt := make(map[string]*extensionStats)
+
+	get := func(ext string) *extensionStats {
+		stats, ok := byExt[ext]
+		if !ok {
+			stats = &extensionStats{ext: ext}
+			byExt[ext] = stats
+		}
+		return stats
+	}
+
+	for _, file := range files {
+		get(summaryExtension(file.Path)).files++
+	}
+
+	for _, group := range groups {
+		seen := make(map[string]bool)
+
+		for i, file := range group {
+			ext := summaryExtension(file.Path)
+
+			if !seen[ext] {
+				seen[ext] = true
+				get(ext).groups++
+			}
+
+			if i > 0 {
+				get(ext).reclaimable += diskSize(file)
+			}
+		}
+	}
+
+	stats := make([]extensionStats, 0, len(byExt))
+	for _, s := range byExt {
+		stats = append(stats, *s)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].reclaimable != stats[j].reclaimable {
+			return stats[i].reclaimable > stats[j].reclaimable
+		}
+		if stats[i].files != stats[j].files {
+			return stats[i].files > stats[j].files
+		}
+		return stats[i].ext < stats[j].ext
+	})
+
+	return stats
+}
+
+func printSummary(files []HashedFileInfo, groups [][]HashedFileInfo) {
+	var totalBytes int64
+	for _, file := range files {
+		totalBytes += file.Size
+	}
+
+	var redundant int
+	for _, group := range groups {
+		redundant += len(group) - 1
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString("\nSummary:\n")
+	fmt.Fprintf(&sb, "  Files scanned:     %d (%s)\n", len(files), formatBytes(totalBytes))
+	fmt.Fprintf(&sb, "  Duplicate groups:  %d\n", len(groups))
+	fmt.Fprintf(&sb, "  Redundant files:   %d\n", redundant)
+	fmt.Fprintf(&sb, "  Reclaimable space: %s\n", formatBytes(reclaimableBytes(groups)))
+
+	if len(files) > 0 {
+		sb.WriteString("\n")
+
+		tw := tabwriter.NewWriter(&sb, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(tw, "  Extension\tFiles\tGroups\tReclaimable")
+		for _, stats := range statsByExtension(files, groups) {
+			fmt.Fprintf(tw, "  %s\t%d\t%d\t%s\n", stats.ext, stats.files, stats.groups, formatBytes(stats.reclaimable))
+		}
+		tw.Flush()
+	}
+
+	printToStdOut(sb.String())
+}
+
+// byteUnits maps the unit suffixes accepted by parseByteSize to their size.
+// Like formatBytes they are binary, so 1 KB is 1024 bytes.
+var byteUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kb":  1 << 10,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mb":  1 << 20,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gb":  1 << 30,
+	"gib": 1 << 30,
+	"t":   1 << 40,
+	"tb":  1 << 40,
+	"tib": 1 << 40,
+}
+
+// parseByteSize parses sizes such as 512, 100MB or 1.5 GB.
+func parseByteSize(s string) (int64, error) {
+	trimmed := strings.TrimSpace(s)
+
+	split := strings.IndexFunc(trimmed, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if split == -1 {
+		split = len(trimmed)
+	}
+
+	number, unit := trimmed[:split], strings.ToLower(strings.TrimSpace(trimmed[split:]))
+
+	multiplier, ok := byteUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, unit)
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return int64(value * float64(multiplier)), nil
+}
+
+// byteSize is a flag value holding a number of bytes, given in the form
+// accepted by parseByteSize.
+type byteSize int64
+
+func (b *byteSize) String() string {
+	// "0" keeps the flag help from listing an unset size as a default.
+	if *b == 0 {
+		return "0"
+	}
+
+	return formatBytes(int64(*b))
+}
+
+func (b *byteSize) Set(s string) error {
+	n, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+
+	*b = byteSize(n)
+
+	return nil
+}
+
+func (b *byteSize) Type() string {
+	return "size"
+}
+
+// formatBytes renders n using binary units, e.g. 4.2 GB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	value := float64(n)
+	units := []string{"KB", "MB", "GB", "TB", "PB", "EB"}
+
+	i := -1
+	for value >= unit && i < len(units)-1 {
+		value /= unit
+		i++
+	}
+
+	return fmt.Sprintf("%.1f %s", value, units[i])
+}
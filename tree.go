@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	ansiBold  = "\x1b[1m"
+	ansiDim   = "\x1b[2m"
+	ansiReset = "\x1b[0m"
+)
+
+// colorEnabled reports whether stdout output may use ANSI colors: only on a
+// terminal, and never with --no-color or the NO_COLOR convention.
+func colorEnabled() bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func colorize(s string, code string, enabled bool) string {
+	if !enabled {
+		return s
+	}
+
+	return code + s + ansiReset
+}
+
+// printTree prints each duplicate group as a node labelled with the shared
+// hash and size, with the member paths as its children.
+func printTree(files []HashedFileInfo, groups [][]HashedFileInfo) {
+	color := colorEnabled()
+
+	var sb strings.Builder
+
+	for _, group := range groups {
+		sb.WriteString("\n")
+
+		reclaimable := reclaimableBytes([][]HashedFileInfo{group})
+		details := fmt.Sprintf("(%d files, %s each, %s reclaimable)", len(group), formatBytes(group[0].Size), formatBytes(reclaimable))
+
+		fmt.Fprintf(&sb, "%s %s\n", colorize(group[0].Hash, ansiBold, color), colorize(details, ansiDim, color))
+
+		for j, file := range group {
+			branch := "├── "
+			if j == len(group)-1 {
+				branch = "└── "
+			}
+
+			fmt.Fprintf(&sb, "%s%s\n", branch, file.Path)
+		}
+	}
+
+	if len(groups) == 0 {
+		sb.WriteString("\nNo duplicate groups found\n")
+	}
+
+	printToStdOut(sb.String())
+
+	printSummary(files, groups)
+}
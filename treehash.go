@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// treeSuffix, appended to an algorithm name, selects the tree variant of
+// that algorithm, e.g. sha256-tree or blake3-tree.
+const treeSuffix = "-tree"
+
+// treeChunkSize is the size of the chunks a tree hash splits content into.
+// It is fixed, so a tree hash does not depend on how many chunks were
+// hashed at a time.
+const treeChunkSize = 64 * 1024 * 1024
+
+// treeHash is a hash.Hash that splits what is written to it into chunks of
+// treeChunkSize, hashes each chunk on its own and hashes the concatenated
+// chunk digests into the final digest. The chunks of a file can therefore
+// be hashed in parallel; see hashChunks. A tree digest differs from the
+// plain digest of the same algorithm.
+type treeHash struct {
+	newBase func() hash.Hash
+	chunk   hash.Hash
+	written int64
+	leaves  []byte
+}
+
+func newTreeHash(newBase func() hash.Hash) *treeHash {
+	return &treeHash{newBase: newBase, chunk: newBase()}
+}
+
+func (t *treeHash) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		room := treeChunkSize - t.written
+		part := p[:min(int64(len(p)), room)]
+
+		t.chunk.Write(part)
+		t.written += int64(len(part))
+		p = p[len(part):]
+
+		if t.written == treeChunkSize {
+			t.addLeaf(t.chunk.Sum(nil))
+			t.chunk.Reset()
+			t.written = 0
+		}
+	}
+
+	return n, nil
+}
+
+func (t *treeHash) addLeaf(digest []byte) {
+	t.leaves = append(t.leaves, digest...)
+}
+
+// Sum hashes the chunk digests, including that of a last partial chunk,
+// behind a label so a tree digest never equals the plain digest of some
+// other content.
+func (t *treeHash) Sum(b []byte) []byte {
+	leaves := t.leaves
+	if t.written > 0 {
+		leaves = append(leaves[:len(leaves):len(leaves)], t.chunk.Sum(nil)...)
+	}
+
+	root := t.newBase()
+	root.Write([]byte("\x00tree\x00"))
+	root.Write(leaves)
+
+	return root.Sum(b)
+}
+
+func (t *treeHash) Reset() {
+	t.chunk.Reset()
+	t.written = 0
+	t.leaves = nil
+}
+
+func (t *treeHash) Size() int {
+	return t.chunk.Size()
+}
+
+func (t *treeHash) BlockSize() int {
+	return t.chunk.BlockSize()
+}
+
+// hashChunks hashes the whole chunks of file with workers goroutines and
+// adds their digests to tree, which must be empty. It returns how many
+// bytes that covered, so the caller can hash the rest after them. onRead,
+// when not nil, is told of the progress as with hashFile.
+func hashChunks(ctx context.Context, tree *treeHash, file *os.File, size int64, workers int, onRead func(done int64, total int64)) (int64, error) {
+	chunks := int(size / treeChunkSize)
+	digests := make([][]byte, chunks)
+
+	var mu sync.Mutex
+	var done int64
+
+	err := runWorkers(ctx, chunks, workers, func(i int) error {
+		chunk := tree.newBase()
+		section := io.NewSectionReader(file, int64(i)*treeChunkSize, treeChunkSize)
+
+		buf := copyBuffers.Get().(*[]byte)
+		defer copyBuffers.Put(buf)
+
+		for {
+			n, err := section.Read(*buf)
+			chunk.Write((*buf)[:n])
+
+			if onRead != nil && n > 0 {
+				mu.Lock()
+				done += int64(n)
+				onRead(done, size)
+				mu.Unlock()
+			}
+
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+
+		digests[i] = chunk.Sum(nil)
+		return nil
+	})
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, digest := range digests {
+		tree.addLeaf(digest)
+	}
+
+	return int64(chunks) * treeChunkSize, nil
+}
+
+func validateChunkWorkers(n int) error {
+	if n < 1 {
+		return fmt.Errorf("hash concurrency per file must be at least 1: %d", n)
+	}
+
+	if n > 1 && !strings.HasSuffix(hashOpts.algo, treeSuffix) {
+		printWarning(fmt.Sprintf("--hash-concurrency-per-file only applies to tree algorithms such as %s%s", hashOpts.algo, treeSuffix))
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkHashFileTree measures how hashing the chunks of a large file in
+// parallel speeds up a tree algorithm, against the plain algorithm read
+// serially. The file spans four chunks and is read from the page cache
+// after the first run, so the figures depend on the cores more than on the
+// storage.
+func BenchmarkHashFileTree(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "large")
+
+	content := make([]byte, 4*treeChunkSize)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+
+	err := os.WriteFile(path, content, 0o644)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	run := func(name string, opts hashOptions) {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(content)))
+			for range b.N {
+				_, err := hashFile(context.Background(), path, opts, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+
+	for _, algo := range []string{"sha256", "blake3"} {
+		run(algo, hashOptions{algo: algo, chunkWorkers: 1})
+
+		for _, workers := range []int{1, 2, 4, 8} {
+			run(fmt.Sprintf("%s%s/workers=%d", algo, treeSuffix, workers), hashOptions{algo: algo + treeSuffix, chunkWorkers: workers})
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	verifyManifest string
+	verifyTimeout  time.Duration
+)
+
+func init() {
+	rootCmd.Flags().StringVar(&verifyManifest, "verify", "", "Compare the scanned files against a results CSV at this path or http(s) URL instead of writing a new one")
+	rootCmd.Flags().DurationVar(&verifyTimeout, "verify-timeout", 30*time.Second, "Timeout for fetching a --verify manifest over HTTP")
+}
+
+func isURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// loadManifest reads a results CSV or a checksum list (see parseManifest)
+// from a local file or, for http:// and https:// locations, downloads it.
+func loadManifest(location string, timeout time.Duration) ([]HashedFileInfo, error) {
+	if !isURL(location) {
+		path, err := expandHome(location)
+		if err != nil {
+			return nil, err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open results file: %w", err)
+		}
+		defer file.Close()
+
+		return parseManifest(file, path)
+	}
+
+	client := &http.Client{Timeout: timeout}
+
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest from %s: %w", location, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch manifest from %s: server returned %s", location, resp.Status)
+	}
+
+	return parseManifest(resp.Body, location)
+}
+
+// verifyAgainstManifest reports how the scanned files compare to manifest.
+// Manifests usually come from another machine, so entries are matched by
+// the local path relative to folderPath appearing as a suffix of the
+// manifest path rather than by the full path. Hashes are compared as
+// prefixes so manifests written with --hash-length still match.
+func verifyAgainstManifest(folderPath string, files []HashedFileInfo, manifest []HashedFileInfo) error {
+	bySuffix := make(map[string][]int)
+	for i, entry := range manifest {
+		for _, suffix := range pathSuffixes(entry.Path) {
+			bySuffix[suffix] = append(bySuffix[suffix], i)
+		}
+	}
+
+	matchedEntries := make([]bool, len(manifest))
+	var ok, mismatched, extra, missing int
+
+	for _, file := range files {
+		rel, err := filepath.Rel(folderPath, file.Path)
+		if err != nil {
+			rel = file.Path
+		}
+		rel = filepath.ToSlash(rel)
+
+		index := -1
+		for _, i := range bySuffix[rel] {
+			if matchedEntries[i] {
+				continue
+			}
+			if index == -1 || len(manifest[i].Path) < len(manifest[index].Path) {
+				index = i
+			}
+		}
+
+		if index == -1 {
+			extra++
+			printToStdOut(fmt.Sprintf("NOT IN MANIFEST: %s\n", file.Path))
+			continue
+		}
+
+		matchedEntries[index] = true
+		entry := manifest[index]
+
+		if !hashesMatch(entry.Hash, file.Hash) {
+			mismatched++
+			printToStdOut(fmt.Sprintf("MISMATCH: %s (expected %s, got %s)\n", file.Path, entry.Hash, file.Hash))
+			continue
+		}
+
+		ok++
+	}
+
+	for i, entry := range manifest {
+		if !matchedEntries[i] {
+			missing++
+			printToStdOut(fmt.Sprintf("MISSING: %s\n", entry.Path))
+		}
+	}
+
+	printToStdOut(fmt.Sprintf("Verified %d files: %d match, %d mismatched, %d missing, %d not in manifest\n",
+		len(files), ok, mismatched, missing, extra))
+
+	if mismatched > 0 || missing > 0 {
+		return fmt.Errorf("verification failed: %d mismatched, %d missing", mismatched, missing)
+	}
+
+	return nil
+}
+
+// pathSuffixes returns every trailing run of path components of path, using
+// forward slashes, e.g. a/b/c yields a/b/c, b/c and c.
+func pathSuffixes(path string) []string {
+	normalized := strings.TrimLeft(strings.ReplaceAll(path, "\\", "/"), "/")
+
+	suffixes := []string{normalized}
+	for i, c := range normalized {
+		if c == '/' {
+			suffixes = append(suffixes, normalized[i+1:])
+		}
+	}
+
+	return suffixes
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	// fallbackMaxOpenFiles is the default --max-open-files where the
+	// process's open file limit cannot be determined.
+	fallbackMaxOpenFiles = 64
+	// maxDefaultOpenFiles caps the default on systems with a very high
+	// limit; more open files than that does not make hashing faster.
+	maxDefaultOpenFiles = 1024
+)
+
+// openFiles limits how many files are open for hashing at once so that a
+// large worker pool cannot exhaust the process's file descriptors.
+var openFiles chan struct{}
+
+func setMaxOpenFiles(n int) {
+	openFiles = make(chan struct{}, n)
+}
+
+func acquireFile() {
+	if openFiles != nil {
+		openFiles <- struct{}{}
+	}
+}
+
+func releaseFile() {
+	if openFiles != nil {
+		<-openFiles
+	}
+}
+
+// runWorkers calls fn for every index in [0, n) using up to workers
+// goroutines. After the first error, or once ctx is canceled, no further
+// indexes are started and the error is returned once the running calls
+// have finished.
+func runWorkers(ctx context.Context, n int, workers int, fn func(i int) error) error {
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	failed := make(chan struct{})
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				err := fn(i)
+				if err != nil {
+					once.Do(func() {
+						firstErr = err
+						close(failed)
+					})
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for i := 0; i < n; i++ {
+		select {
+		case jobs <- i:
+		case <-failed:
+			break dispatch
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	if firstErr == nil {
+		return ctx.Err()
+	}
+
+	return firstErr
+}
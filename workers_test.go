@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunWorkersStopsWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var started atomic.Int64
+	running := make(chan struct{}, 4)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runWorkers(ctx, 1000, 4, func(i int) error {
+			started.Add(1)
+			running <- struct{}{}
+
+			// Like hashing a large file, each call only ends once it notices
+			// the cancellation.
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+
+	for range 4 {
+		<-running
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("runWorkers returned %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("runWorkers did not return within 2s of the cancellation")
+	}
+
+	if n := started.Load(); n != 4 {
+		t.Errorf("started %d calls, want only the 4 running when canceled", n)
+	}
+}
+
+func TestScanWithOneOpenFile(t *testing.T) {
+	quietOutput(t)
+
+	dir := t.TempDir()
+	contents := make(map[string]string)
+	for i := range 50 {
+		contents[fmt.Sprintf("file%02d.txt", i)] = fmt.Sprint(i % 5)
+	}
+	writeFiles(t, dir, contents)
+
+	setMaxOpenFiles(1)
+	t.Cleanup(func() { openFiles = nil })
+
+	opts := testScanOptions()
+	opts.workers = 16
+
+	type result struct {
+		files []HashedFileInfo
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		files, err := processFiles(context.Background(), []string{dir}, opts)
+		done <- result{files, err}
+	}()
+
+	var scan result
+	select {
+	case scan = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("scan with --max-open-files 1 and 16 workers did not finish")
+	}
+
+	if scan.err != nil {
+		t.Fatalf("scan failed: %v", scan.err)
+	}
+
+	if len(scan.files) != len(contents) {
+		t.Fatalf("scan returned %d files, want %d", len(scan.files), len(contents))
+	}
+
+	if groups := findDuplicateGroups(scan.files, 2, matchHash); len(groups) != 5 {
+		t.Errorf("found %d duplicate groups, want 5", len(groups))
+	}
+}